@@ -5,36 +5,121 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/spf13/pflag"
 	"golang.org/x/net/websocket"
 )
 
 var CableConfig struct {
-	Channel  string
-	Encoding string
+	Channel           string
+	Encoding          string
+	TurboStream       string
+	TurboSecret       string
+	TurboStreamGroups int
+}
+
+func init() {
+	flags := pflag.NewFlagSet("actioncable", pflag.ContinueOnError)
+	flags.StringVar(&CableConfig.Channel, "channel", "{\"channel\":\"BenchmarkChannel\"}", "Action Cable channel identifier")
+	flags.StringVar(&CableConfig.Encoding, "action-cable-encoding", "json", "Action Cable messages encoding (json, msgpack, protobuf)")
+	flags.StringVar(&CableConfig.TurboStream, "turbo-stream", "", "Turbo Streams stream name template to subscribe to instead of --channel, actioncable-connect only (e.g. \"chat:{{.Group}}\", \"user:{{.ID}}\")")
+	flags.StringVar(&CableConfig.TurboSecret, "turbo-secret", "", "Turbo Streams signed stream verifier key")
+	flags.IntVar(&CableConfig.TurboStreamGroups, "turbo-stream-groups", 1, "number of client groups for the {{.Group}} stream name placeholder")
+
+	RegisterServerType(&ServerType{
+		Name:            "actioncable",
+		New:             newActionCableServerAdapter,
+		Flags:           flags,
+		Configure:       configureActionCable,
+		SessionRecovery: true,
+	})
+	RegisterServerType(&ServerType{
+		Name:      "actioncable-connect",
+		New:       newActionCableServerConnectAdapter,
+		Flags:     flags,
+		Configure: configureTurboStreams,
+	})
+}
+
+// configureActionCable rejects --turbo-stream: Turbo::StreamsChannel broadcasts
+// the HTML and has no echo and broadcast actions to measure
+func configureActionCable() error {
+	if CableConfig.TurboStream != "" {
+		return errors.New("--turbo-stream is only supported by the actioncable-connect server type")
+	}
+
+	return configureTurboStreams()
 }
 
 type ActionCableServerAdapter struct {
-	conn      *websocket.Conn
-	connected bool
-	mu        sync.Mutex
-	codec     websocket.Codec
+	conn       *websocket.Conn
+	clientID   int
+	identity   Identity
+	identifier string
+	connected  bool
+	mu         sync.Mutex
+	codec      websocket.Codec
+
+	// Extended protocol (actioncable-v1-ext-*) state
+	extended  bool
+	sid       string
+	streamsMu sync.Mutex
+	streams   map[string]*acsaStreamPosition
+
+	// recovery receives the result of the session restoration,
+	// which completes with the awaited confirmation message
+	recovery chan error
+	awaiting string
 }
 
 type acsaMsg struct {
-	Type       string      `json:"type,omitempty"`
-	Command    string      `json:"command,omitempty"`
-	Identifier string      `json:"identifier,omitempty"`
-	Data       string      `json:"data,omitempty"`
-	Message    interface{} `json:"message,omitempty"`
+	Type        string       `json:"type,omitempty"`
+	Command     string       `json:"command,omitempty"`
+	Identifier  string       `json:"identifier,omitempty"`
+	Data        string       `json:"data,omitempty"`
+	Message     interface{}  `json:"message,omitempty"`
+	Sid         string       `json:"sid,omitempty"`
+	Restored    bool         `json:"restored,omitempty"`
+	RestoredIDs []string     `json:"restored_ids,omitempty"`
+	StreamID    string       `json:"stream_id,omitempty"`
+	Epoch       string       `json:"epoch,omitempty"`
+	Offset      uint64       `json:"offset,omitempty"`
+	History     *acsaHistory `json:"history,omitempty"`
+	Reason      string       `json:"reason,omitempty"`
+}
+
+type acsaHistory struct {
+	Since   int64                          `json:"since,omitempty"`
+	Streams map[string]*acsaStreamPosition `json:"streams,omitempty"`
+}
+
+type acsaStreamPosition struct {
+	Epoch  string `json:"epoch"`
+	Offset uint64 `json:"offset"`
+}
+
+func newActionCableServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	acsa := &ActionCableServerAdapter{conn: conn, clientID: client.ID, identity: client.Identity}
+	if err := acsa.Startup(); err != nil {
+		return nil, err
+	}
+	return acsa, nil
 }
 
 func (acsa *ActionCableServerAdapter) Startup() error {
 	acsa.connected = false
 
+	identifier, err := cableIdentifier(acsa.clientID, acsa.identity)
+	if err != nil {
+		return err
+	}
+	acsa.identifier = identifier
+
 	if CableConfig.Encoding == "msgpack" {
 		acsa.codec = MsgPackCodec
 	} else if CableConfig.Encoding == "protobuf" {
@@ -43,9 +128,32 @@ func (acsa *ActionCableServerAdapter) Startup() error {
 		acsa.codec = websocket.JSON
 	}
 
+	protocol := acsa.conn.Config().Protocol
+	acsa.extended = len(protocol) == 1 && strings.HasPrefix(protocol[0], "actioncable-v1-ext-")
+	acsa.streams = make(map[string]*acsaStreamPosition)
+
 	return nil
 }
 
+func (acsa *ActionCableServerAdapter) RestoreHeader() http.Header {
+	header := make(http.Header)
+	if acsa.extended && acsa.sid != "" {
+		header.Set("X-AnyCable-Restore-Sid", acsa.sid)
+	}
+	return header
+}
+
+func (acsa *ActionCableServerAdapter) Restore(conn *websocket.Conn) <-chan error {
+	acsa.mu.Lock()
+	defer acsa.mu.Unlock()
+
+	acsa.conn = conn
+	acsa.connected = false
+	acsa.recovery = make(chan error, 1)
+
+	return acsa.recovery
+}
+
 func (acsa *ActionCableServerAdapter) EnsureConnected(ctx context.Context) error {
 	acsa.mu.Lock()
 	defer acsa.mu.Unlock()
@@ -67,10 +175,9 @@ func (acsa *ActionCableServerAdapter) EnsureConnected(ctx context.Context) error
 			return
 		}
 
-		err = acsa.codec.Send(acsa.conn, &acsaMsg{
-			Command:    "subscribe",
-			Identifier: CableConfig.Channel,
-		})
+		acsa.sid = welcomeMsg.Sid
+
+		err = acsa.subscribe(welcomeMsg)
 		if err != nil {
 			resChan <- err
 			return
@@ -110,7 +217,7 @@ func (acsa *ActionCableServerAdapter) SendEcho(payload *Payload) error {
 
 	return acsa.codec.Send(acsa.conn, &acsaMsg{
 		Command:    "message",
-		Identifier: CableConfig.Channel,
+		Identifier: acsa.identifier,
 		Data:       string(data),
 	})
 }
@@ -134,12 +241,12 @@ func (acsa *ActionCableServerAdapter) SendBroadcast(payload *Payload) error {
 
 	return acsa.codec.Send(acsa.conn, &acsaMsg{
 		Command:    "message",
-		Identifier: CableConfig.Channel,
+		Identifier: acsa.identifier,
 		Data:       string(data),
 	})
 }
 
-func (acsa *ActionCableServerAdapter) Receive() (*serverSentMsg, error) {
+func (acsa *ActionCableServerAdapter) Receive() (*ServerSentMsg, error) {
 	if !acsa.connected {
 		ctx, cancel := context.WithTimeout(context.Background(), ConnectionTimeout)
 		defer cancel()
@@ -157,14 +264,30 @@ func (acsa *ActionCableServerAdapter) Receive() (*serverSentMsg, error) {
 	}
 
 	if msg.Message == nil {
-		panic(fmt.Errorf("Message is nil for %v", msg))
+		return nil, fmt.Errorf("Message is nil for %v", msg)
 	}
 
-	message := msg.Message.(map[string]interface{})
-	payloadMap := message["payload"].(map[string]interface{})
+	if msg.StreamID != "" {
+		acsa.streamsMu.Lock()
+		acsa.streams[msg.StreamID] = &acsaStreamPosition{Epoch: msg.Epoch, Offset: msg.Offset}
+		acsa.streamsMu.Unlock()
+	}
+
+	message, ok := msg.Message.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected message: %v", msg.Message)
+	}
+	payloadMap, ok := message["payload"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("message payload not found: %v", message)
+	}
+	sendTime, ok := payloadMap["sendTime"].(string)
+	if !ok {
+		return nil, fmt.Errorf("message sendTime not found: %v", message)
+	}
 
 	payload := &Payload{}
-	unixNanosecond, err := strconv.ParseInt(payloadMap["sendTime"].(string), 10, 64)
+	unixNanosecond, err := strconv.ParseInt(sendTime, 10, 64)
 	if err != nil {
 		return nil, err
 	}
@@ -178,12 +301,81 @@ func (acsa *ActionCableServerAdapter) Receive() (*serverSentMsg, error) {
 		payload.Padding = paddingJson
 	}
 
-	msgType, err := ParseMessageType(message["action"].(string))
+	action, ok := message["action"].(string)
+	if !ok {
+		return nil, fmt.Errorf("message action not found: %v", message)
+	}
+
+	msgType, err := ParseMessageType(action)
 	if err != nil {
 		return nil, err
 	}
 
-	return &serverSentMsg{Type: msgType, Payload: payload}, nil
+	return &ServerSentMsg{Type: msgType, Payload: payload}, nil
+}
+
+// subscribe subscribes to the channel after the welcome message; for restored
+// sessions it only requests the history missed while reconnecting
+func (acsa *ActionCableServerAdapter) subscribe(welcome *acsaMsg) error {
+	history := acsa.history()
+
+	if welcome.Restored && containsString(welcome.RestoredIDs, acsa.identifier) {
+		if history == nil {
+			acsa.finishRecovery(nil)
+			return nil
+		}
+
+		acsa.awaiting = "confirm_history"
+
+		return acsa.codec.Send(acsa.conn, &acsaMsg{
+			Command:    "history",
+			Identifier: acsa.identifier,
+			History:    history,
+		})
+	}
+
+	if history != nil {
+		acsa.awaiting = "confirm_history"
+	} else {
+		acsa.awaiting = "confirm_subscription"
+	}
+
+	return acsa.codec.Send(acsa.conn, &acsaMsg{
+		Command:    "subscribe",
+		Identifier: acsa.identifier,
+		History:    history,
+	})
+}
+
+// history returns the known stream positions to request the missed messages from
+func (acsa *ActionCableServerAdapter) history() *acsaHistory {
+	if !acsa.extended {
+		return nil
+	}
+
+	acsa.streamsMu.Lock()
+	defer acsa.streamsMu.Unlock()
+
+	if len(acsa.streams) == 0 {
+		return nil
+	}
+
+	history := &acsaHistory{Streams: make(map[string]*acsaStreamPosition)}
+	for id, pos := range acsa.streams {
+		history.Streams[id] = &acsaStreamPosition{Epoch: pos.Epoch, Offset: pos.Offset}
+	}
+
+	return history
+}
+
+func (acsa *ActionCableServerAdapter) finishRecovery(err error) {
+	if acsa.recovery == nil {
+		return
+	}
+
+	acsa.recovery <- err
+	acsa.recovery = nil
+	acsa.awaiting = ""
 }
 
 func (acsa *ActionCableServerAdapter) receiveIgnoringPing() (*acsaMsg, error) {
@@ -194,14 +386,34 @@ func (acsa *ActionCableServerAdapter) receiveIgnoringPing() (*acsaMsg, error) {
 			return nil, err
 		}
 
-		if msg.Type == "ping" || msg.Type == "confirm_subscription" {
+		switch msg.Type {
+		case "ping":
 			continue
-		}
-
-		if msg.Type == "reject_subscription" {
-			return nil, errors.New("Subscription rejected")
+		case "confirm_subscription", "confirm_history":
+			if msg.Type == acsa.awaiting {
+				acsa.finishRecovery(nil)
+			}
+			continue
+		case "reject_history":
+			acsa.finishRecovery(errors.New("History request rejected"))
+			continue
+		case "reject_subscription":
+			err := classify(ErrorClassSubscriptionRejected, errors.New("Subscription rejected"))
+			acsa.finishRecovery(err)
+			return nil, err
+		case "disconnect":
+			return nil, classify(disconnectClass(msg.Reason), fmt.Errorf("disconnected: %s", msg.Reason))
 		}
 
 		return &msg, nil
 	}
 }
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package benchmark
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -10,14 +11,35 @@ import (
 )
 
 type ActionCableServerConnectAdapter struct {
-	conn      *websocket.Conn
-	initTime  time.Time
-	connected bool
-	mu        sync.Mutex
-	codec     websocket.Codec
+	conn       *websocket.Conn
+	clientID   int
+	identity   Identity
+	identifier string
+	initTime   time.Time
+	phases     *Phases
+	connected  bool
+	mu         sync.Mutex
+	codec      websocket.Codec
+}
+
+func newActionCableServerConnectAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	acsa := &ActionCableServerConnectAdapter{conn: conn, clientID: client.ID, identity: client.Identity, phases: client.Phases}
+	if err := acsa.Startup(); err != nil {
+		return nil, err
+	}
+	if err := acsa.Connected(client.InitTime); err != nil {
+		return nil, err
+	}
+	return acsa, nil
 }
 
 func (acsa *ActionCableServerConnectAdapter) Startup() error {
+	identifier, err := cableIdentifier(acsa.clientID, acsa.identity)
+	if err != nil {
+		return err
+	}
+	acsa.identifier = identifier
+
 	if CableConfig.Encoding == "msgpack" {
 		acsa.codec = MsgPackCodec
 	} else if CableConfig.Encoding == "protobuf" {
@@ -35,7 +57,7 @@ func (acsa *ActionCableServerConnectAdapter) Connected(ts time.Time) error {
 	return nil
 }
 
-func (acsa *ActionCableServerConnectAdapter) Receive() (*serverSentMsg, error) {
+func (acsa *ActionCableServerConnectAdapter) Receive() (*ServerSentMsg, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ConnectionTimeout)
 	defer cancel()
 
@@ -50,7 +72,7 @@ func (acsa *ActionCableServerConnectAdapter) Receive() (*serverSentMsg, error) {
 	payload.SendTime = acsa.initTime
 
 	// use echo type to collect results
-	return &serverSentMsg{Type: MsgServerEcho, Payload: payload}, nil
+	return &ServerSentMsg{Type: MsgServerEcho, Payload: payload}, nil
 }
 
 func (acsa *ActionCableServerConnectAdapter) SendEcho(payload *Payload) error {
@@ -93,14 +115,21 @@ func (acsa *ActionCableServerConnectAdapter) EnsureConnected(ctx context.Context
 			resChan <- err
 			return
 		}
+		// The connection is rejected (e.g. with an expired token), record how long it took
+		if welcomeMsg.Type == "disconnect" {
+			stepMetrics.AddLatency("rejection", time.Since(acsa.initTime))
+			resChan <- classify(disconnectClass(welcomeMsg.Reason), fmt.Errorf("connection rejected: %s", welcomeMsg.Reason))
+			return
+		}
 		if welcomeMsg.Type != "welcome" {
 			resChan <- fmt.Errorf("expected welcome msg, got %v", welcomeMsg)
 			return
 		}
+		acsa.phases.Mark("welcome")
 
 		err = acsa.codec.Send(acsa.conn, &acsaMsg{
 			Command:    "subscribe",
-			Identifier: CableConfig.Channel,
+			Identifier: acsa.identifier,
 		})
 		if err != nil {
 			resChan <- err
@@ -113,10 +142,15 @@ func (acsa *ActionCableServerConnectAdapter) EnsureConnected(ctx context.Context
 			return
 		}
 
+		if confirmMsg.Type == "reject_subscription" {
+			resChan <- classify(ErrorClassSubscriptionRejected, errors.New("Subscription rejected"))
+			return
+		}
 		if confirmMsg.Type != "confirm_subscription" {
 			resChan <- fmt.Errorf("expected confirm msg, got %v", confirmMsg)
 			return
 		}
+		acsa.phases.Mark("subscription")
 
 		resChan <- nil
 	}()
@@ -1,6 +1,7 @@
 package benchmark
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -24,6 +25,13 @@ type Benchmark struct {
 	Config
 
 	clients []Client
+
+	// victims are the clients to be dropped during the current step,
+	// they don't send commands
+	victims map[Client]bool
+
+	// startedCount is the number of clients started so far, it's the next client ID
+	startedCount int
 }
 
 type Config struct {
@@ -45,6 +53,7 @@ type Config struct {
 	StepDelay             time.Duration
 	CommandDelay          time.Duration
 	CommandDelayChance    int
+	DropPercentage        int
 	WaitBroadcastsSeconds int
 	ClientPools           []ClientPool
 	ResultRecorder        ResultRecorder
@@ -84,7 +93,16 @@ func (b *Benchmark) Run() error {
 
 		stepDrop := 0
 
+		if len(b.clients) == 0 {
+			return errors.New("no clients connected")
+		}
+
+		b.chooseVictims()
+		var recoveries sync.WaitGroup
+		dropped := false
+
 		bar := pb.StartNew(b.SampleSize)
+		stepErrors.Attempt(ErrorKindCommand, b.SampleSize)
 
 		inProgress := 0
 		for i := 0; i < b.Concurrent; i++ {
@@ -103,9 +121,16 @@ func (b *Benchmark) Run() error {
 				inProgress--
 			case err := <-b.errChan:
 				stepDrop++
+				stepErrors.Add(ErrorKindCommand, err)
 				debug(fmt.Sprintf("error: %v", err))
 			}
 
+			// Drop the connections in the middle of the step
+			if !dropped && len(b.victims) > 0 && rttAgg.Count()+stepDrop >= b.SampleSize/2 {
+				dropped = true
+				b.dropVictims(&recoveries)
+			}
+
 			if rttAgg.Count()+inProgress+stepDrop < b.SampleSize {
 				if err := b.sendToRandomClient(); err != nil {
 					return err
@@ -116,9 +141,13 @@ func (b *Benchmark) Run() error {
 
 		bar.Finish()
 
+		recoveries.Wait()
+		b.victims = nil
+
 		drop += stepDrop
 
 		expectedRxBroadcastCount += (len(b.clients) - drop) * b.SampleSize
+		finishClusterStep()
 
 		if (b.TotalSteps > 0 && b.TotalSteps == stepNum) || (b.TotalSteps == 0 && b.LimitRTT < rttAgg.Percentile(b.LimitPercentile)) {
 			finished = true
@@ -151,6 +180,9 @@ func (b *Benchmark) Run() error {
 						fmt.Sprintf("Extra received broadcasts: expected %d, got %d", expectedRxBroadcastCount, totalRxBroadcastCount),
 					)
 				}
+				for _, msg := range recordClusterBroadcasts() {
+					b.ResultRecorder.Message(msg)
+				}
 			}
 		}
 
@@ -166,6 +198,14 @@ func (b *Benchmark) Run() error {
 			return err
 		}
 
+		if err := recordStepMetrics(b.ResultRecorder, b.LimitPercentile); err != nil {
+			return err
+		}
+
+		if err := recordStepErrors(b.ResultRecorder); err != nil {
+			return err
+		}
+
 		if finished {
 			return nil
 		}
@@ -185,7 +225,9 @@ func (b *Benchmark) Run() error {
 func (b *Benchmark) startClients(serverType string, total int, concurrent int) {
 	bar := pb.Simple.Start(total)
 	created := 0
-	counter := len(b.clients)
+	stepErrors.Attempt(ErrorKindConnect, total)
+	counter := b.startedCount
+	b.startedCount += total
 
 	for created < total {
 		var waitgroup sync.WaitGroup
@@ -196,19 +238,23 @@ func (b *Benchmark) startClients(serverType string, total int, concurrent int) {
 		for i := 0; i < toCreate; i++ {
 			waitgroup.Add(1)
 
-			go func() {
+			go func(i, id int) {
 				cp := b.ClientPools[i%len(b.ClientPools)]
-				client, err := cp.New(counter, b.WebsocketURL, b.WebsocketOrigin, b.ServerType, b.rttResultChan, b.errChan, b.payloadPadding)
+				client, err := cp.New(id, b.WebsocketURL, b.WebsocketOrigin, b.ServerType, b.rttResultChan, b.errChan, b.payloadPadding)
 
+				mu.Lock()
+				// The failed clients aren't added, they're reported as the connect_failed counter
 				if err != nil {
-					b.errChan <- err
+					stepMetrics.Add("connect_failed", 1)
+					stepErrors.Add(ErrorKindConnect, err)
+					debug(fmt.Sprintf("connect error: %v", err))
+				} else {
+					b.clients = append(b.clients, client)
 				}
-				mu.Lock()
-				b.clients = append(b.clients, client)
 				bar.Increment()
 				mu.Unlock()
 				waitgroup.Done()
-			}()
+			}(i, counter+created+i)
 		}
 		waitgroup.Wait()
 		created += toCreate
@@ -222,7 +268,48 @@ func (b *Benchmark) randomClient() Client {
 		panic("no clients")
 	}
 
-	return b.clients[rand.Intn(len(b.clients))]
+	for {
+		client := b.clients[rand.Intn(len(b.clients))]
+		if !b.victims[client] {
+			return client
+		}
+	}
+}
+
+// chooseVictims picks DropPercentage of the clients to drop during the step
+func (b *Benchmark) chooseVictims() {
+	b.victims = nil
+
+	count := len(b.clients) * b.DropPercentage / 100
+	if count == 0 {
+		return
+	}
+
+	b.victims = make(map[Client]bool, count)
+	for _, i := range rand.Perm(len(b.clients))[:count] {
+		b.victims[b.clients[i]] = true
+	}
+}
+
+// dropVictims drops the victims connections and restores their sessions;
+// the recovery times are reported as the "recovery" step metric
+func (b *Benchmark) dropVictims(recoveries *sync.WaitGroup) {
+	for client := range b.victims {
+		recoveries.Add(1)
+
+		go func(client Client) {
+			defer recoveries.Done()
+
+			duration, err := client.Reconnect()
+			if err != nil {
+				stepMetrics.Add("recovery_failed", 1)
+				debug(fmt.Sprintf("recovery error: %v", err))
+				return
+			}
+
+			stepMetrics.AddLatency("recovery", duration)
+		}(client)
+	}
 }
 
 func (b *Benchmark) sendToRandomClient() error {
@@ -10,6 +10,15 @@ type BinaryServerAdapter struct {
 	conn *websocket.Conn
 }
 
+func init() {
+	RegisterServerType(&ServerType{
+		Name: "binary",
+		New: func(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+			return &BinaryServerAdapter{conn: conn}, nil
+		},
+	})
+}
+
 func payloadToBinaryMsg(msgType byte, payload *Payload) []byte {
 	// message type - byte (1 byte)
 	// payload size - int32 (4 bytes)
@@ -33,14 +42,14 @@ func (bsa *BinaryServerAdapter) SendBroadcast(payload *Payload) error {
 	return websocket.Message.Send(bsa.conn, payloadToBinaryMsg(MsgClientBroadcast, payload))
 }
 
-func (bsa *BinaryServerAdapter) Receive() (*serverSentMsg, error) {
+func (bsa *BinaryServerAdapter) Receive() (*ServerSentMsg, error) {
 	var buf []byte
 	err := websocket.Message.Receive(bsa.conn, &buf)
 	if err != nil {
 		return nil, err
 	}
 
-	var msg serverSentMsg
+	var msg ServerSentMsg
 	msg.Type = buf[0]
 	// ignoring payload size as it can be inferred from frame size -- may need to revisit this if messages span frames
 	payload := &Payload{
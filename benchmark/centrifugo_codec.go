@@ -0,0 +1,97 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/websocket"
+
+	"github.com/anycable/websocket-bench/centrifugo"
+)
+
+// Centrifuge codecs marshal a single *centrifugo.Command and unmarshal
+// a frame into *[]*centrifugo.Reply, since servers may batch several replies
+// into one frame.
+
+func centrifugoJSONMarshal(v interface{}) (msg []byte, payloadType byte, err error) {
+	cmd, ok := v.(*centrifugo.Command)
+	if !ok {
+		return nil, 0, errors.New("Unsupported message struct")
+	}
+
+	msg, err = json.Marshal(cmd)
+
+	return msg, websocket.TextFrame, err
+}
+
+func centrifugoJSONUnmarshal(msg []byte, payloadType byte, v interface{}) (err error) {
+	replies, ok := v.(*[]*centrifugo.Reply)
+	if !ok {
+		return errors.New("Unsupported message struct")
+	}
+
+	for _, line := range bytes.Split(msg, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		reply := &centrifugo.Reply{}
+		if err := json.Unmarshal(line, reply); err != nil {
+			return err
+		}
+
+		*replies = append(*replies, reply)
+	}
+
+	return nil
+}
+
+func centrifugoProtobufMarshal(v interface{}) (msg []byte, payloadType byte, err error) {
+	cmd, ok := v.(*centrifugo.Command)
+	if !ok {
+		return nil, 0, errors.New("Unsupported message struct")
+	}
+
+	b, err := proto.Marshal(cmd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to marshal protobuf: %v. Error: %v", cmd, err)
+	}
+
+	// Commands are varint length-delimited
+	msg = make([]byte, binary.MaxVarintLen64+len(b))
+	n := binary.PutUvarint(msg, uint64(len(b)))
+	msg = append(msg[:n], b...)
+
+	return msg, websocket.BinaryFrame, nil
+}
+
+func centrifugoProtobufUnmarshal(msg []byte, payloadType byte, v interface{}) (err error) {
+	replies, ok := v.(*[]*centrifugo.Reply)
+	if !ok {
+		return errors.New("Unsupported message struct")
+	}
+
+	for len(msg) > 0 {
+		size, n := binary.Uvarint(msg)
+		if n <= 0 || uint64(len(msg)-n) < size {
+			return errors.New("Malformed length-delimited protobuf frame")
+		}
+
+		reply := &centrifugo.Reply{}
+		if err := proto.Unmarshal(msg[n:n+int(size)], reply); err != nil {
+			return fmt.Errorf("Failed to unmarshal protobuf: %v", err)
+		}
+
+		*replies = append(*replies, reply)
+		msg = msg[n+int(size):]
+	}
+
+	return nil
+}
+
+var CentrifugoJSONCodec = websocket.Codec{Marshal: centrifugoJSONMarshal, Unmarshal: centrifugoJSONUnmarshal}
+var CentrifugoProtobufCodec = websocket.Codec{Marshal: centrifugoProtobufMarshal, Unmarshal: centrifugoProtobufUnmarshal}
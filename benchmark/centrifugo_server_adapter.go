@@ -0,0 +1,241 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+
+	"github.com/anycable/websocket-bench/centrifugo"
+)
+
+var CentrifugoConfig struct {
+	Channel  string
+	Encoding string
+	Token    string
+}
+
+func init() {
+	flags := pflag.NewFlagSet("centrifugo", pflag.ContinueOnError)
+	flags.StringVar(&CentrifugoConfig.Channel, "centrifugo-channel", "benchmark", "Centrifugo channel to subscribe and publish to")
+	flags.StringVar(&CentrifugoConfig.Encoding, "centrifugo-encoding", "json", "Centrifugo protocol encoding (json, protobuf)")
+	flags.StringVar(&CentrifugoConfig.Token, "centrifugo-token", "", "Centrifugo connection token")
+
+	RegisterServerType(&ServerType{
+		Name:  "centrifugo",
+		New:   newCentrifugoServerAdapter,
+		Flags: flags,
+		Handshake: func(config *websocket.Config) {
+			if CentrifugoConfig.Encoding == "protobuf" {
+				config.Protocol = []string{"centrifuge-protobuf"}
+			}
+		},
+	})
+}
+
+// CentrifugoServerAdapter speaks the Centrifuge client protocol:
+// echo is an RPC call (the server must have an RPC handler for the "echo" method)
+// and broadcast is a publication into the subscribed channel.
+type CentrifugoServerAdapter struct {
+	conn  *websocket.Conn
+	codec websocket.Codec
+	pong  bool
+
+	mu      sync.Mutex
+	lastID  uint32
+	pending map[uint32]*csaRequest
+
+	replies []*centrifugo.Reply
+}
+
+// csaRequest is a command waiting for the server reply
+type csaRequest struct {
+	msgType byte
+	payload *Payload
+}
+
+func newCentrifugoServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	csa := &CentrifugoServerAdapter{conn: conn}
+	if err := csa.Startup(); err != nil {
+		return nil, err
+	}
+	return csa, nil
+}
+
+func (csa *CentrifugoServerAdapter) Startup() error {
+	if CentrifugoConfig.Encoding == "protobuf" {
+		csa.codec = CentrifugoProtobufCodec
+	} else {
+		csa.codec = CentrifugoJSONCodec
+	}
+
+	csa.pending = make(map[uint32]*csaRequest)
+
+	connectReply, err := csa.call(&centrifugo.Command{
+		Connect: &centrifugo.ConnectRequest{Token: CentrifugoConfig.Token, Name: "websocket-bench"},
+	})
+	if err != nil {
+		return err
+	}
+
+	if connectReply.Connect == nil {
+		return fmt.Errorf("expected connect reply, got %v", connectReply)
+	}
+
+	csa.pong = connectReply.Connect.Pong
+
+	subscribeReply, err := csa.call(&centrifugo.Command{
+		Subscribe: &centrifugo.SubscribeRequest{Channel: CentrifugoConfig.Channel},
+	})
+	if err != nil {
+		return err
+	}
+
+	if subscribeReply.Subscribe == nil {
+		return fmt.Errorf("expected subscribe reply, got %v", subscribeReply)
+	}
+
+	return nil
+}
+
+func (csa *CentrifugoServerAdapter) SendEcho(payload *Payload) error {
+	data, err := json.Marshal(payloadTojsonPayload(payload))
+	if err != nil {
+		return err
+	}
+
+	return csa.send(MsgServerEcho, payload, &centrifugo.Command{
+		RPC: &centrifugo.RPCRequest{Method: "echo", Data: data},
+	})
+}
+
+func (csa *CentrifugoServerAdapter) SendBroadcast(payload *Payload) error {
+	data, err := json.Marshal(payloadTojsonPayload(payload))
+	if err != nil {
+		return err
+	}
+
+	return csa.send(MsgServerBroadcastResult, payload, &centrifugo.Command{
+		Publish: &centrifugo.PublishRequest{Channel: CentrifugoConfig.Channel, Data: data},
+	})
+}
+
+func (csa *CentrifugoServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		reply, err := csa.receiveIgnoringPing()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.Push != nil {
+			if reply.Push.Disconnect != nil {
+				return nil, fmt.Errorf("disconnected by server: %s (%d)", reply.Push.Disconnect.Reason, reply.Push.Disconnect.Code)
+			}
+
+			// Skip join/leave and other pushes
+			if reply.Push.Pub == nil {
+				continue
+			}
+
+			payload, err := rawToPayload(reply.Push.Pub.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			return &ServerSentMsg{Type: MsgServerBroadcast, Payload: payload}, nil
+		}
+
+		csa.mu.Lock()
+		req, ok := csa.pending[reply.Id]
+		delete(csa.pending, reply.Id)
+		csa.mu.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("unexpected reply: %v", reply)
+		}
+
+		if reply.Error != nil {
+			return nil, fmt.Errorf("command failed: %s (%d)", reply.Error.Message, reply.Error.Code)
+		}
+
+		return &ServerSentMsg{Type: req.msgType, Payload: req.payload}, nil
+	}
+}
+
+func (csa *CentrifugoServerAdapter) send(msgType byte, payload *Payload, cmd *centrifugo.Command) error {
+	csa.mu.Lock()
+	csa.lastID++
+	cmd.Id = csa.lastID
+	csa.pending[cmd.Id] = &csaRequest{msgType: msgType, payload: payload}
+	csa.mu.Unlock()
+
+	return csa.codec.Send(csa.conn, cmd)
+}
+
+// call sends a command and waits for its reply; it's only used during startup,
+// when no other commands are in flight.
+func (csa *CentrifugoServerAdapter) call(cmd *centrifugo.Command) (*centrifugo.Reply, error) {
+	csa.lastID++
+	cmd.Id = csa.lastID
+
+	if err := csa.codec.Send(csa.conn, cmd); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := csa.receiveIgnoringPing()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.Id != cmd.Id {
+			continue
+		}
+
+		if reply.Error != nil {
+			return nil, fmt.Errorf("command failed: %s (%d)", reply.Error.Message, reply.Error.Code)
+		}
+
+		return reply, nil
+	}
+}
+
+func (csa *CentrifugoServerAdapter) receiveIgnoringPing() (*centrifugo.Reply, error) {
+	for {
+		for len(csa.replies) == 0 {
+			if err := csa.codec.Receive(csa.conn, &csa.replies); err != nil {
+				return nil, err
+			}
+		}
+
+		reply := csa.replies[0]
+		csa.replies = csa.replies[1:]
+
+		if reply.IsPing() {
+			if csa.pong {
+				if err := csa.codec.Send(csa.conn, &centrifugo.Command{}); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		return reply, nil
+	}
+}
+
+func rawToPayload(data []byte) (*Payload, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty payload")
+	}
+
+	var jp jsonPayload
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return nil, err
+	}
+
+	return jsonPayloadToPayload(&jp)
+}
@@ -13,6 +13,7 @@ type Client interface {
 	SendEcho() error
 	SendBroadcast() error
 	ResetRxBroadcastCount() (int, error)
+	Reconnect() (time.Duration, error)
 }
 
 type ClientPool interface {
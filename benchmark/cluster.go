@@ -0,0 +1,240 @@
+package benchmark
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Node assignment strategies
+const (
+	// NodeStrategyRoundRobin assigns the clients to the nodes in turn
+	NodeStrategyRoundRobin = "round-robin"
+	// NodeStrategyWeighted assigns the clients to the nodes in proportion to the weights
+	NodeStrategyWeighted = "weighted"
+	// NodeStrategyHash assigns the clients to the nodes by the hash of the client ID,
+	// so a client gets the same node in every run (the client IDs are unique)
+	NodeStrategyHash = "hash"
+)
+
+// Cluster spreads the clients across several WebSocket URLs (nodes).
+//
+// The RTT and the number of connections are reported per node, and so are
+// the broadcasts: the latency and the number of missing broadcasts are broken
+// down by the sender and the receiver nodes (e.g. "broadcast@a->b").
+var Cluster struct {
+	Strategy string
+	Weights  []int
+
+	nodes []*clusterNode
+	next  uint32
+}
+
+type clusterNode struct {
+	// name is the node URL host
+	name     string
+	location *url.URL
+	raddr    *net.TCPAddr
+	host     string
+	secure   bool
+
+	// clients is the number of the connected clients
+	clients int32
+}
+
+// AddClusterNode adds the WebSocket URL to the cluster nodes
+func AddClusterNode(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range Cluster.nodes {
+		if node.name == u.Host {
+			return fmt.Errorf("duplicate cluster node: %s", u.Host)
+		}
+	}
+
+	raddr, host, err := ResolveRemoteAddr(u.Host)
+	if err != nil {
+		return err
+	}
+
+	Cluster.nodes = append(Cluster.nodes, &clusterNode{
+		name:     u.Host,
+		location: u,
+		raddr:    raddr,
+		host:     host,
+		secure:   u.Scheme == "wss",
+	})
+
+	return nil
+}
+
+// ConfigureCluster validates the node assignment strategy and the weights
+func ConfigureCluster() error {
+	switch Cluster.Strategy {
+	case NodeStrategyRoundRobin, NodeStrategyHash:
+		if len(Cluster.Weights) > 0 {
+			return fmt.Errorf("node weights require the %s strategy", NodeStrategyWeighted)
+		}
+	case NodeStrategyWeighted:
+		if len(Cluster.Weights) != len(Cluster.nodes) {
+			return fmt.Errorf("expected %d node weights, got %d", len(Cluster.nodes), len(Cluster.Weights))
+		}
+		for _, w := range Cluster.Weights {
+			if w <= 0 {
+				return fmt.Errorf("invalid node weight: %d", w)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown node strategy: %s", Cluster.Strategy)
+	}
+
+	return nil
+}
+
+// pickNode returns the node for the client (nil unless there are several nodes)
+func pickNode(id int) *clusterNode {
+	nodes := Cluster.nodes
+	if len(nodes) < 2 {
+		return nil
+	}
+
+	switch Cluster.Strategy {
+	case NodeStrategyHash:
+		h := fnv.New32a()
+		h.Write([]byte(strconv.Itoa(id)))
+		return nodes[h.Sum32()%uint32(len(nodes))]
+	case NodeStrategyWeighted:
+		total := 0
+		for _, w := range Cluster.Weights {
+			total += w
+		}
+
+		n := int((atomic.AddUint32(&Cluster.next, 1) - 1) % uint32(total))
+		for i, w := range Cluster.Weights {
+			if n < w {
+				return nodes[i]
+			}
+			n -= w
+		}
+	}
+
+	n := atomic.AddUint32(&Cluster.next, 1) - 1
+	return nodes[n%uint32(len(nodes))]
+}
+
+// nodePair is the (sender, receiver) pair of the broadcast nodes
+type nodePair [2]*clusterNode
+
+func (p nodePair) String() string {
+	return p[0].name + "->" + p[1].name
+}
+
+// clusterBroadcasts keeps the cross-node broadcast counts
+var clusterBroadcasts = struct {
+	sync.Mutex
+	// senders are the sender nodes by the broadcast ID (see trackBroadcastSent);
+	// the previous step ones are kept for the late broadcasts
+	senders     map[int64]*clusterNode
+	prevSenders map[int64]*clusterNode
+	lastID      int64
+	// sent is the number of broadcasts sent by the nodes during the step
+	sent     map[*clusterNode]int
+	expected map[nodePair]int
+	received map[nodePair]int
+}{
+	senders:  make(map[int64]*clusterNode),
+	sent:     make(map[*clusterNode]int),
+	expected: make(map[nodePair]int),
+	received: make(map[nodePair]int),
+}
+
+// trackBroadcastSent assigns the broadcast ID and records the sender node.
+//
+// The server adapters only pass the send time through the server, so the send
+// time (Unix nanoseconds) is the broadcast ID: it's moved a nanosecond past the
+// previous broadcast ID, so the broadcasts sent at the same time don't merge.
+func trackBroadcastSent(node *clusterNode, payload *Payload) {
+	clusterBroadcasts.Lock()
+	defer clusterBroadcasts.Unlock()
+
+	id := payload.SendTime.UnixNano()
+	if id <= clusterBroadcasts.lastID {
+		id = clusterBroadcasts.lastID + 1
+		payload.SendTime = time.Unix(0, id)
+	}
+	clusterBroadcasts.lastID = id
+
+	clusterBroadcasts.senders[id] = node
+	clusterBroadcasts.sent[node]++
+}
+
+// trackBroadcastReceived reports the broadcast latency by the sender and the receiver nodes
+func trackBroadcastReceived(node *clusterNode, payload *Payload) {
+	// The send time is the broadcast ID
+	key := payload.SendTime.UnixNano()
+
+	clusterBroadcasts.Lock()
+	sender, ok := clusterBroadcasts.senders[key]
+	if !ok {
+		sender, ok = clusterBroadcasts.prevSenders[key]
+	}
+	if ok {
+		clusterBroadcasts.received[nodePair{sender, node}]++
+	}
+	clusterBroadcasts.Unlock()
+
+	if ok {
+		stepMetrics.AddLatency("broadcast@"+nodePair{sender, node}.String(), time.Since(payload.SendTime))
+	}
+}
+
+// finishClusterStep updates the expected broadcast counts: every client
+// connected to the receiver node gets the broadcasts sent by the sender node
+func finishClusterStep() {
+	clusterBroadcasts.Lock()
+	defer clusterBroadcasts.Unlock()
+
+	for sender, count := range clusterBroadcasts.sent {
+		for _, receiver := range Cluster.nodes {
+			clusterBroadcasts.expected[nodePair{sender, receiver}] += count * int(atomic.LoadInt32(&receiver.clients))
+		}
+	}
+
+	clusterBroadcasts.sent = make(map[*clusterNode]int)
+	clusterBroadcasts.prevSenders = clusterBroadcasts.senders
+	clusterBroadcasts.senders = make(map[int64]*clusterNode)
+}
+
+// recordClusterBroadcasts reports the missing broadcasts by the sender and
+// the receiver nodes and returns the messages about the mismatched counts
+func recordClusterBroadcasts() []string {
+	clusterBroadcasts.Lock()
+	defer clusterBroadcasts.Unlock()
+
+	var messages []string
+	for pair, expected := range clusterBroadcasts.expected {
+		received := clusterBroadcasts.received[pair]
+
+		missing := expected - received
+		if missing < 0 {
+			missing = 0
+		}
+		stepMetrics.Add("broadcasts_missing@"+pair.String(), missing)
+
+		if received != expected {
+			messages = append(messages, fmt.Sprintf("Broadcasts %s: expected %d, got %d", pair, expected, received))
+		}
+	}
+	sort.Strings(messages)
+
+	return messages
+}
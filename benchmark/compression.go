@@ -0,0 +1,470 @@
+package benchmark
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var CompressionConfig struct {
+	Enabled                 bool
+	Level                   int
+	ClientNoContextTakeover bool
+	ServerNoContextTakeover bool
+	ClientMaxWindowBits     int
+	ServerMaxWindowBits     int
+	ByteStats               bool
+}
+
+// WebSocket frame opcodes
+const (
+	wsContinuationFrame = 0x0
+	wsTextFrame         = 0x1
+	wsBinaryFrame       = 0x2
+)
+
+const (
+	deflateWindowSize  = 1 << 15
+	deflateSyncTrailer = "\x00\x00\xff\xff"
+)
+
+// frameConn sits between the network connection and x/net/websocket, which
+// can't negotiate extensions. It adds the permessage-deflate (RFC 7692) offer
+// to the handshake request, strips the negotiated extension from the response,
+// and then compresses outgoing and decompresses incoming data frames so that
+// x/net/websocket only sees plain frames.
+//
+// It also counts the bytes on the wire and the application (uncompressed
+// payload) bytes, which are reported as step metrics.
+type frameConn struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+
+	handshakeSent bool
+	handshakeDone bool
+
+	wbuf []byte
+	rbuf []byte
+
+	// negotiated permessage-deflate parameters (nil if not negotiated)
+	deflate *deflateParams
+
+	compressor    *flate.Writer
+	compressed    bytes.Buffer
+	decompressor  io.ReadCloser
+	dict          []byte
+	msgOpCode     byte
+	msgCompressed bool
+	msgData       []byte
+}
+
+type deflateParams struct {
+	clientNoContextTakeover bool
+	serverNoContextTakeover bool
+	clientMaxWindowBits     int
+}
+
+type wsFrameHeader struct {
+	fin     bool
+	rsv1    bool
+	opCode  byte
+	mask    []byte
+	length  int
+	wireLen int
+}
+
+// useFrameConn returns true if the connections must be wrapped with frameConn
+func useFrameConn() bool {
+	return CompressionConfig.Enabled || CompressionConfig.ByteStats
+}
+
+func newFrameConn(conn io.ReadWriteCloser) *frameConn {
+	return &frameConn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (fc *frameConn) Close() error {
+	return fc.conn.Close()
+}
+
+func (fc *frameConn) Write(p []byte) (int, error) {
+	fc.wbuf = append(fc.wbuf, p...)
+
+	if !fc.handshakeSent {
+		end := bytes.Index(fc.wbuf, []byte("\r\n\r\n"))
+		if end < 0 {
+			return len(p), nil
+		}
+
+		request := append([]byte(nil), fc.wbuf[:end+2]...)
+		if CompressionConfig.Enabled {
+			request = append(request, "Sec-WebSocket-Extensions: "+deflateOffer()+"\r\n"...)
+		}
+		request = append(request, "\r\n"...)
+
+		if _, err := fc.conn.Write(request); err != nil {
+			return 0, err
+		}
+
+		fc.wbuf = fc.wbuf[end+4:]
+		fc.handshakeSent = true
+	}
+
+	for {
+		header, ok := parseFrameHeader(fc.wbuf)
+		if !ok || len(fc.wbuf) < header.wireLen {
+			return len(p), nil
+		}
+
+		payload := fc.wbuf[header.wireLen-header.length : header.wireLen]
+		frame := fc.wbuf[:header.wireLen]
+
+		if header.opCode <= wsBinaryFrame {
+			stepMetrics.Add("app_bytes_sent", header.length)
+
+			if fc.deflate != nil && header.fin && header.opCode != wsContinuationFrame {
+				maskBytes(header.mask, payload)
+
+				data, err := fc.compress(payload)
+				if err != nil {
+					return 0, err
+				}
+
+				maskBytes(header.mask, data)
+				frame = appendFrame(nil, true, true, header.opCode, header.mask, data)
+			}
+		}
+
+		if _, err := fc.conn.Write(frame); err != nil {
+			return 0, err
+		}
+		stepMetrics.Add("wire_bytes_sent", len(frame))
+
+		fc.wbuf = fc.wbuf[header.wireLen:]
+	}
+}
+
+func (fc *frameConn) Read(p []byte) (int, error) {
+	for len(fc.rbuf) == 0 {
+		var err error
+		if !fc.handshakeDone {
+			fc.rbuf, err = fc.readHandshake()
+			fc.handshakeDone = true
+		} else {
+			fc.rbuf, err = fc.readFrame()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, fc.rbuf)
+	fc.rbuf = fc.rbuf[n:]
+
+	return n, nil
+}
+
+// readHandshake reads the handshake response head and removes the negotiated extension from it
+func (fc *frameConn) readHandshake() ([]byte, error) {
+	var head []byte
+
+	for {
+		line, err := fc.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.ToLower(strings.SplitN(line, ":", 2)[0])
+		if CompressionConfig.Enabled && name == "sec-websocket-extensions" {
+			params, err := parseDeflateResponse(strings.SplitN(line, ":", 2)[1])
+			if err != nil {
+				return nil, err
+			}
+			fc.deflate = params
+			continue
+		}
+
+		head = append(head, line...)
+
+		if line == "\r\n" || line == "\n" {
+			return head, nil
+		}
+	}
+}
+
+// readFrame reads the next frame and returns the frames to pass to x/net/websocket:
+// compressed messages are reassembled and passed as a single plain frame
+func (fc *frameConn) readFrame() ([]byte, error) {
+	// The first two bytes tell the header size, so short frames (e.g. empty
+	// pings) are read without waiting for the following ones
+	start, err := fc.r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	peek, err := fc.r.Peek(frameHeaderSize(start[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	header, ok := parseFrameHeader(peek)
+	if !ok {
+		return nil, errors.New("malformed WebSocket frame header")
+	}
+
+	frame := make([]byte, header.wireLen)
+	if _, err := io.ReadFull(fc.r, frame); err != nil {
+		return nil, err
+	}
+	stepMetrics.Add("wire_bytes_received", len(frame))
+
+	// Control frames may be interleaved with the message fragments
+	if header.opCode > wsBinaryFrame {
+		return frame, nil
+	}
+
+	payload := frame[header.wireLen-header.length:]
+
+	if header.opCode != wsContinuationFrame {
+		fc.msgOpCode = header.opCode
+		fc.msgCompressed = header.rsv1 && fc.deflate != nil
+		fc.msgData = fc.msgData[:0]
+	}
+
+	if !fc.msgCompressed {
+		stepMetrics.Add("app_bytes_received", header.length)
+		return frame, nil
+	}
+
+	fc.msgData = append(fc.msgData, payload...)
+	if !header.fin {
+		return nil, nil
+	}
+
+	data, err := fc.decompress(fc.msgData)
+	if err != nil {
+		return nil, err
+	}
+	stepMetrics.Add("app_bytes_received", len(data))
+
+	return appendFrame(nil, true, false, fc.msgOpCode, nil, data), nil
+}
+
+func (fc *frameConn) compress(data []byte) ([]byte, error) {
+	if fc.compressor == nil {
+		level := CompressionConfig.Level
+		// Go's compressor always uses the full window, so only Huffman
+		// coding (no back-references) complies with a smaller one
+		if fc.deflate.clientMaxWindowBits > 0 && fc.deflate.clientMaxWindowBits < 15 {
+			level = flate.HuffmanOnly
+		}
+
+		w, err := flate.NewWriter(&fc.compressed, level)
+		if err != nil {
+			return nil, err
+		}
+		fc.compressor = w
+	} else if fc.deflate.clientNoContextTakeover {
+		fc.compressor.Reset(&fc.compressed)
+	}
+
+	fc.compressed.Reset()
+
+	if _, err := fc.compressor.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fc.compressor.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(fc.compressed.Bytes(), []byte(deflateSyncTrailer)), nil
+}
+
+func (fc *frameConn) decompress(data []byte) ([]byte, error) {
+	// Restore the sync trailer and add an empty final block to end the stream
+	src := io.MultiReader(bytes.NewReader(data), strings.NewReader(deflateSyncTrailer+"\x01\x00\x00\xff\xff"))
+
+	if fc.decompressor == nil {
+		fc.decompressor = flate.NewReaderDict(src, fc.dict)
+	} else if err := fc.decompressor.(flate.Resetter).Reset(src, fc.dict); err != nil {
+		return nil, err
+	}
+
+	out, err := ioutil.ReadAll(fc.decompressor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Previous messages are used as the dictionary unless the server resets the context
+	if !fc.deflate.serverNoContextTakeover {
+		fc.dict = append(fc.dict, out...)
+		if len(fc.dict) > deflateWindowSize {
+			fc.dict = fc.dict[len(fc.dict)-deflateWindowSize:]
+		}
+	}
+
+	return out, nil
+}
+
+// deflateOffer returns the permessage-deflate extension offer
+func deflateOffer() string {
+	offer := []string{"permessage-deflate"}
+
+	if CompressionConfig.ClientNoContextTakeover {
+		offer = append(offer, "client_no_context_takeover")
+	}
+	if CompressionConfig.ServerNoContextTakeover {
+		offer = append(offer, "server_no_context_takeover")
+	}
+	if CompressionConfig.ServerMaxWindowBits > 0 {
+		offer = append(offer, "server_max_window_bits="+strconv.Itoa(CompressionConfig.ServerMaxWindowBits))
+	}
+	if CompressionConfig.ClientMaxWindowBits > 0 {
+		offer = append(offer, "client_max_window_bits="+strconv.Itoa(CompressionConfig.ClientMaxWindowBits))
+	} else {
+		offer = append(offer, "client_max_window_bits")
+	}
+
+	return strings.Join(offer, "; ")
+}
+
+func parseDeflateResponse(header string) (*deflateParams, error) {
+	params := strings.Split(strings.TrimSpace(header), ";")
+	if strings.TrimSpace(params[0]) != "permessage-deflate" {
+		return nil, fmt.Errorf("unsupported extension: %s", strings.TrimSpace(header))
+	}
+
+	dp := &deflateParams{}
+
+	for _, param := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		switch kv[0] {
+		case "client_no_context_takeover":
+			dp.clientNoContextTakeover = true
+		case "server_no_context_takeover":
+			dp.serverNoContextTakeover = true
+		case "client_max_window_bits":
+			if len(kv) == 2 {
+				bits, err := strconv.Atoi(strings.Trim(kv[1], "\""))
+				if err != nil {
+					return nil, fmt.Errorf("invalid client_max_window_bits: %s", kv[1])
+				}
+				dp.clientMaxWindowBits = bits
+			}
+		case "server_max_window_bits":
+		default:
+			return nil, fmt.Errorf("unsupported permessage-deflate parameter: %s", kv[0])
+		}
+	}
+
+	return dp, nil
+}
+
+// frameHeaderSize returns the frame header size by its second byte
+// (the mask bit and the payload length)
+func frameHeaderSize(b byte) int {
+	size := 2
+
+	switch b & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+
+	if b&0x80 != 0 {
+		size += 4
+	}
+
+	return size
+}
+
+// parseFrameHeader returns the frame header if buf contains it entirely
+func parseFrameHeader(buf []byte) (*wsFrameHeader, bool) {
+	if len(buf) < 2 {
+		return nil, false
+	}
+
+	header := &wsFrameHeader{
+		fin:    buf[0]&0x80 != 0,
+		rsv1:   buf[0]&0x40 != 0,
+		opCode: buf[0] & 0x0f,
+	}
+
+	pos := 2
+	length := uint64(buf[1] & 0x7f)
+
+	switch length {
+	case 126:
+		if len(buf) < pos+2 {
+			return nil, false
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[pos:]))
+		pos += 2
+	case 127:
+		if len(buf) < pos+8 {
+			return nil, false
+		}
+		length = binary.BigEndian.Uint64(buf[pos:])
+		pos += 8
+	}
+
+	if buf[1]&0x80 != 0 {
+		if len(buf) < pos+4 {
+			return nil, false
+		}
+		header.mask = append([]byte(nil), buf[pos:pos+4]...)
+		pos += 4
+	}
+
+	header.length = int(length)
+	header.wireLen = pos + header.length
+
+	return header, true
+}
+
+func appendFrame(buf []byte, fin, rsv1 bool, opCode byte, mask, payload []byte) []byte {
+	b := opCode
+	if fin {
+		b |= 0x80
+	}
+	if rsv1 {
+		b |= 0x40
+	}
+	buf = append(buf, b)
+
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		buf = append(buf, maskBit|byte(length))
+	case length < 65536:
+		buf = append(buf, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		buf = append(append(buf, maskBit|127), ext[:]...)
+	}
+
+	buf = append(buf, mask...)
+
+	return append(buf, payload...)
+}
+
+func maskBytes(mask, data []byte) {
+	if mask == nil {
+		return
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
@@ -40,6 +40,7 @@ func (b *ConnectBenchmark) Run() error {
 		stepNum++
 
 		bar := pb.StartNew(b.StepSize)
+		stepErrors.Attempt(ErrorKindConnect, b.StepSize)
 
 		go b.startClients(b.Concurrent, b.StepSize)
 
@@ -50,6 +51,7 @@ func (b *ConnectBenchmark) Run() error {
 				bar.Increment()
 				resAgg.Add(result)
 			case err := <-b.errChan:
+				stepErrors.Add(ErrorKindConnect, err)
 				debug(fmt.Sprintf("error: %v", err))
 				stepDrop++
 				bar.Increment()
@@ -61,7 +63,7 @@ func (b *ConnectBenchmark) Run() error {
 		drop += stepDrop
 
 		err := b.ResultRecorder.Record(
-			int(b.clientsCount)-drop,
+			int(atomic.LoadUint64(&b.clientsCount))-drop,
 			b.LimitPercentile,
 			resAgg.Percentile(b.LimitPercentile),
 			resAgg.Min(),
@@ -73,6 +75,14 @@ func (b *ConnectBenchmark) Run() error {
 			return err
 		}
 
+		if err := recordStepMetrics(b.ResultRecorder, b.LimitPercentile); err != nil {
+			return err
+		}
+
+		if err := recordStepErrors(b.ResultRecorder); err != nil {
+			return err
+		}
+
 		if b.Interactive {
 			promptToContinue()
 		}
@@ -88,14 +98,15 @@ func (b *ConnectBenchmark) Run() error {
 }
 
 func (b *ConnectBenchmark) startClient(serverType string) error {
-	cp := b.ClientPools[int(b.clientsCount)%len(b.ClientPools)]
-
 	if b.CommandDelay > 0 && b.CommandDelayChance > rand.Intn(100) {
 		time.Sleep(b.CommandDelay)
 	}
 
-	atomic.AddUint64(&b.clientsCount, 1)
-	_, err := cp.New(int(b.clientsCount), b.WebsocketURL, b.WebsocketOrigin, b.ServerType, b.resChan, b.errChan, nil)
+	// The client IDs start at 0 like in the echo and broadcast benchmarks
+	id := int(atomic.AddUint64(&b.clientsCount, 1)) - 1
+	cp := b.ClientPools[id%len(b.ClientPools)]
+
+	_, err := cp.New(id, b.WebsocketURL, b.WebsocketOrigin, b.ServerType, b.resChan, b.errChan, nil)
 
 	if err != nil {
 		return err
@@ -1,6 +1,7 @@
 package benchmark
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -20,6 +21,8 @@ func ParseMessageType(s string) (byte, error) {
 	}
 }
 
+// payloadTojsonPayload splits the padding into the object keyed by the
+// decimal indexes ("0", "1", ...)
 func payloadTojsonPayload(payload *Payload) *jsonPayload {
 	sendTime := strconv.FormatInt(payload.SendTime.UnixNano(), 10)
 	paddingValues := strings.Split(string(payload.Padding), "0")
@@ -27,12 +30,26 @@ func payloadTojsonPayload(payload *Payload) *jsonPayload {
 	padding := make(map[string]interface{})
 
 	for ind, val := range paddingValues {
-		padding[string(ind)] = val
+		padding[strconv.Itoa(ind)] = val
 	}
 
 	return &jsonPayload{SendTime: sendTime, Padding: padding}
 }
 
+func jsonPayloadToPayload(jp *jsonPayload) (*Payload, error) {
+	var strPadding string
+
+	if jp.Padding != nil {
+		paddingJson, err := json.Marshal(jp.Padding)
+		if err != nil {
+			return nil, err
+		}
+		strPadding = string(paddingJson)
+	}
+
+	return stringToBinaryPayload(jp.SendTime, strPadding)
+}
+
 func stringToBinaryPayload(strSendTime, strPadding string) (*Payload, error) {
 	var payload Payload
 
@@ -48,3 +65,26 @@ func stringToBinaryPayload(strSendTime, strPadding string) (*Payload, error) {
 
 	return &payload, nil
 }
+
+// findSendTime looks up the first sendTime field in the decoded JSON value
+func findSendTime(data interface{}) (string, bool) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if sendTime, ok := v["sendTime"].(string); ok {
+			return sendTime, true
+		}
+		for _, field := range v {
+			if sendTime, ok := findSendTime(field); ok {
+				return sendTime, true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if sendTime, ok := findSendTime(item); ok {
+				return sendTime, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,226 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Error classes set where the errors occur; the rest are classified by errorClass
+const (
+	ErrorClassDialRefused          = "dial_refused"
+	ErrorClassDialTimeout          = "dial_timeout"
+	ErrorClassDialError            = "dial_error"
+	ErrorClassLocalPortsExhausted  = "local_ports_exhausted"
+	ErrorClassTLSFailure           = "tls_failure"
+	ErrorClassUpgradeFailure       = "upgrade_failure"
+	ErrorClassSubscriptionRejected = "subscription_rejected"
+	ErrorClassDisconnect           = "disconnect"
+	ErrorClassDecodeError          = "decode_error"
+	ErrorClassReadEOF              = "read_eof"
+	ErrorClassReadTimeout          = "read_timeout"
+	ErrorClassNetworkError         = "network_error"
+	ErrorClassOther                = "other"
+)
+
+// classifiedError is the error with the class it's reported under
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func classify(class string, err error) error {
+	return &classifiedError{class: class, err: err}
+}
+
+func (ce *classifiedError) Error() string {
+	return ce.err.Error()
+}
+
+func (ce *classifiedError) Unwrap() error {
+	return ce.err
+}
+
+// upgradeStatusClass returns the class of the upgrade response status (e.g. upgrade_status_403)
+func upgradeStatusClass(code int) string {
+	return "upgrade_status_" + strconv.Itoa(code)
+}
+
+// disconnectClass returns the class of the server disconnect (e.g. disconnect_unauthorized)
+func disconnectClass(reason string) string {
+	if reason == "" {
+		return ErrorClassDisconnect
+	}
+	return ErrorClassDisconnect + "_" + strings.ReplaceAll(reason, " ", "_")
+}
+
+// errorClass returns the class the error is reported under
+func errorClass(err error) string {
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.class
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassReadEOF
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		switch {
+		case opErr.Op == "dial" && errors.Is(err, syscall.ECONNREFUSED):
+			return ErrorClassDialRefused
+		case opErr.Op == "dial" && opErr.Timeout():
+			return ErrorClassDialTimeout
+		case opErr.Op == "dial":
+			return ErrorClassDialError
+		case opErr.Timeout():
+			return ErrorClassReadTimeout
+		}
+		return ErrorClassNetworkError
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &numErr) {
+		return ErrorClassDecodeError
+	}
+
+	return ErrorClassOther
+}
+
+// stepErrors counts the client errors by class, the benchmarks take
+// a snapshot at the end of each step
+var stepErrors = newErrorCounter()
+
+// Error kinds, the error rate of each is calculated for its own unit of work
+const (
+	// ErrorKindConnect are the errors per connection attempt
+	ErrorKindConnect = iota
+	// ErrorKindCommand are the errors per echo or broadcast sample
+	ErrorKindCommand
+)
+
+type errorCounter struct {
+	mu       sync.Mutex
+	counts   [2]map[string]int
+	attempts [2]int
+}
+
+// StepErrors is a snapshot of the errors occurred during a step
+type StepErrors struct {
+	// Connect are the errors per connection attempt
+	Connect ErrorStats
+	// Command are the errors per echo or broadcast sample
+	Command ErrorStats
+}
+
+type ErrorStats struct {
+	Total    int
+	Attempts int
+	// Rate is the share of the failed attempts, in percent
+	Rate   float64
+	Counts []ErrorCount
+}
+
+type ErrorCount struct {
+	Class string
+	Count int
+}
+
+func newErrorCounter() *errorCounter {
+	return &errorCounter{counts: [2]map[string]int{make(map[string]int), make(map[string]int)}}
+}
+
+func (ec *errorCounter) Add(kind int, err error) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.counts[kind][errorClass(err)]++
+}
+
+// Attempt adds the number of connections or samples the error rate is calculated for
+func (ec *errorCounter) Attempt(kind int, n int) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ec.attempts[kind] += n
+}
+
+// Snapshot returns the errors counted since the previous snapshot and resets them
+func (ec *errorCounter) Snapshot() *StepErrors {
+	ec.mu.Lock()
+	counts, attempts := ec.counts, ec.attempts
+	ec.counts = [2]map[string]int{make(map[string]int), make(map[string]int)}
+	ec.attempts = [2]int{}
+	ec.mu.Unlock()
+
+	return &StepErrors{
+		Connect: newErrorStats(counts[ErrorKindConnect], attempts[ErrorKindConnect]),
+		Command: newErrorStats(counts[ErrorKindCommand], attempts[ErrorKindCommand]),
+	}
+}
+
+func newErrorStats(counts map[string]int, attempts int) ErrorStats {
+	stats := ErrorStats{Attempts: attempts}
+
+	for class, count := range counts {
+		stats.Counts = append(stats.Counts, ErrorCount{Class: class, Count: count})
+		stats.Total += count
+	}
+	sort.Slice(stats.Counts, func(i, j int) bool { return stats.Counts[i].Class < stats.Counts[j].Class })
+
+	if attempts > 0 {
+		stats.Rate = float64(stats.Total) * 100 / float64(attempts)
+	}
+
+	return stats
+}
+
+// recordStepErrors passes the errors counted during the step to the recorder
+func recordStepErrors(recorder ResultRecorder) error {
+	return recorder.RecordErrors(stepErrors.Snapshot())
+}
+
+// upgradeConn records the status line of the upgrade response, which
+// x/net/websocket doesn't expose on the handshake failure
+type upgradeConn struct {
+	io.ReadWriteCloser
+	status []byte
+	done   bool
+}
+
+func (uc *upgradeConn) Read(p []byte) (int, error) {
+	n, err := uc.ReadWriteCloser.Read(p)
+
+	if !uc.done {
+		if i := bytes.IndexByte(p[:n], '\n'); i >= 0 {
+			uc.status = append(uc.status, p[:i]...)
+			uc.done = true
+		} else if len(uc.status) < 256 {
+			uc.status = append(uc.status, p[:n]...)
+		}
+	}
+
+	return n, err
+}
+
+// upgradeError classifies the failed upgrade by the response status code
+func (uc *upgradeConn) upgradeError(err error) error {
+	fields := strings.Fields(string(uc.status))
+	if len(fields) >= 2 {
+		if code, convErr := strconv.Atoi(fields[1]); convErr == nil && code != 101 {
+			return classify(upgradeStatusClass(code), err)
+		}
+	}
+
+	return classify(ErrorClassUpgradeFailure, err)
+}
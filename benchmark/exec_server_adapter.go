@@ -0,0 +1,346 @@
+package benchmark
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var ExecConfig struct {
+	Command string
+}
+
+func init() {
+	flags := pflag.NewFlagSet("exec", pflag.ContinueOnError)
+	flags.StringVar(&ExecConfig.Command, "exec-command", "", "command to run as the exec server type codec (e.g. \"python3 codec.py\")")
+
+	RegisterServerType(&ServerType{
+		Name:      "exec",
+		New:       newExecServerAdapter,
+		Flags:     flags,
+		Configure: configureExec,
+	})
+}
+
+// ExecServerAdapter delegates encoding and decoding of messages to an external
+// process, while connections and timing stay on the Go side. All the clients
+// share a single long-running process started with --exec-command.
+//
+// The process reads requests from stdin and writes responses to stdout, one
+// JSON object per line. Every request has an "id", which the response must
+// include; responses may come in any order. Frames are base64-encoded.
+//
+//	{"id":1,"op":"connect","client":42}
+//	{"id":2,"op":"encode","client":42,"type":"echo","sendTime":"1600000000000000000","padding":"MTIz"}
+//	{"id":3,"op":"decode","client":42,"frame":{"data":"eyJ0eXBlIjoiZWNobyJ9","binary":false}}
+//
+//	{"id":1,"frames":[{"data":"eyJjb21tYW5kIjoic3Vic2NyaWJlIn0=","binary":false}]}
+//	{"id":2,"frames":[...]}
+//	{"id":3,"messages":[{"type":"echo","sendTime":"1600000000000000000"}],"frames":[...]}
+//
+// "connect" returns the frames to send after the connection is established
+// (e.g. authentication or subscriptions), "encode" returns the frames for an
+// "echo" or "broadcast" command, and "decode" returns the benchmark messages
+// ("echo", "broadcast" or "broadcastResult") found in the received frame along
+// with the frames to reply with (e.g. pongs). Both lists may be empty.
+// A response with an "error" fails the client.
+type ExecServerAdapter struct {
+	conn     *websocket.Conn
+	clientID int
+
+	queue []*ServerSentMsg
+}
+
+type execRequest struct {
+	ID       int64      `json:"id"`
+	Op       string     `json:"op"`
+	Client   int        `json:"client"`
+	Type     string     `json:"type,omitempty"`
+	SendTime string     `json:"sendTime,omitempty"`
+	Padding  []byte     `json:"padding,omitempty"`
+	Frame    *execFrame `json:"frame,omitempty"`
+}
+
+type execResponse struct {
+	ID       int64         `json:"id"`
+	Error    string        `json:"error,omitempty"`
+	Frames   []*execFrame  `json:"frames,omitempty"`
+	Messages []*execResult `json:"messages,omitempty"`
+}
+
+type execFrame struct {
+	Data   []byte `json:"data"`
+	Binary bool   `json:"binary"`
+}
+
+type execResult struct {
+	Type     string `json:"type"`
+	SendTime string `json:"sendTime"`
+}
+
+// execFrameCodec sends and receives frames keeping their payload type
+var execFrameCodec = websocket.Codec{Marshal: execFrameMarshal, Unmarshal: execFrameUnmarshal}
+
+func execFrameMarshal(v interface{}) (msg []byte, payloadType byte, err error) {
+	frame := v.(*execFrame)
+	if frame.Binary {
+		return frame.Data, websocket.BinaryFrame, nil
+	}
+	return frame.Data, websocket.TextFrame, nil
+}
+
+func execFrameUnmarshal(msg []byte, payloadType byte, v interface{}) (err error) {
+	frame := v.(*execFrame)
+	frame.Data = msg
+	frame.Binary = payloadType == websocket.BinaryFrame
+	return nil
+}
+
+func newExecServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	esa := &ExecServerAdapter{conn: conn, clientID: client.ID}
+	if err := esa.Startup(); err != nil {
+		return nil, err
+	}
+	return esa, nil
+}
+
+func (esa *ExecServerAdapter) Startup() error {
+	res, err := execCodec.call(&execRequest{Op: "connect", Client: esa.clientID})
+	if err != nil {
+		return err
+	}
+
+	return esa.sendFrames(res.Frames)
+}
+
+func (esa *ExecServerAdapter) SendEcho(payload *Payload) error {
+	return esa.send("echo", payload)
+}
+
+func (esa *ExecServerAdapter) SendBroadcast(payload *Payload) error {
+	return esa.send("broadcast", payload)
+}
+
+func (esa *ExecServerAdapter) Receive() (*ServerSentMsg, error) {
+	for len(esa.queue) == 0 {
+		var frame execFrame
+		if err := execFrameCodec.Receive(esa.conn, &frame); err != nil {
+			return nil, err
+		}
+
+		res, err := execCodec.call(&execRequest{Op: "decode", Client: esa.clientID, Frame: &frame})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := esa.sendFrames(res.Frames); err != nil {
+			return nil, err
+		}
+
+		for _, result := range res.Messages {
+			msgType, err := ParseMessageType(result.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			payload, err := stringToBinaryPayload(result.SendTime, "")
+			if err != nil {
+				return nil, err
+			}
+
+			esa.queue = append(esa.queue, &ServerSentMsg{Type: msgType, Payload: payload})
+		}
+	}
+
+	msg := esa.queue[0]
+	esa.queue = esa.queue[1:]
+
+	return msg, nil
+}
+
+func (esa *ExecServerAdapter) send(msgType string, payload *Payload) error {
+	res, err := execCodec.call(&execRequest{
+		Op:       "encode",
+		Client:   esa.clientID,
+		Type:     msgType,
+		SendTime: strconv.FormatInt(payload.SendTime.UnixNano(), 10),
+		Padding:  payload.Padding,
+	})
+	if err != nil {
+		return err
+	}
+
+	return esa.sendFrames(res.Frames)
+}
+
+func (esa *ExecServerAdapter) sendFrames(frames []*execFrame) error {
+	for _, frame := range frames {
+		if err := execFrameCodec.Send(esa.conn, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execCodec is the process shared by the exec adapters
+var execCodec *execProcess
+
+func configureExec() error {
+	if execCodec != nil {
+		return nil
+	}
+
+	args := strings.Fields(ExecConfig.Command)
+	if len(args) == 0 {
+		return errors.New("--exec-command is required for the exec server type")
+	}
+
+	proc, err := startExecProcess(args[0], args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	execCodec = proc
+	return nil
+}
+
+// execProcess multiplexes the requests of all the clients over the process stdio
+type execProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	lastID  int64
+	pending map[int64]chan *execResponse
+	err     error
+}
+
+func startExecProcess(name string, args ...string) (*execProcess, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	ep := &execProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		encoder: json.NewEncoder(stdin),
+		pending: make(map[int64]chan *execResponse),
+	}
+
+	go ep.rx(stdout)
+
+	return ep, nil
+}
+
+func (ep *execProcess) call(req *execRequest) (*execResponse, error) {
+	resChan := make(chan *execResponse, 1)
+
+	ep.mu.Lock()
+	if ep.err != nil {
+		ep.mu.Unlock()
+		return nil, ep.err
+	}
+
+	ep.lastID++
+	req.ID = ep.lastID
+	ep.pending[req.ID] = resChan
+
+	err := ep.encoder.Encode(req)
+	if err != nil {
+		delete(ep.pending, req.ID)
+	}
+	ep.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := <-resChan
+	if !ok {
+		return nil, ep.failure()
+	}
+
+	if res.Error != "" {
+		return nil, fmt.Errorf("exec %s failed: %s", req.Op, res.Error)
+	}
+
+	return res, nil
+}
+
+func (ep *execProcess) rx(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var res execResponse
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			ep.fail(fmt.Errorf("exec: malformed response: %v", err))
+			return
+		}
+
+		ep.mu.Lock()
+		resChan, ok := ep.pending[res.ID]
+		delete(ep.pending, res.ID)
+		ep.mu.Unlock()
+
+		if ok {
+			resChan <- &res
+		}
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("exec: process closed stdout")
+	}
+	ep.fail(err)
+
+	ep.cmd.Wait()
+}
+
+// fail rejects the pending and all the following requests
+func (ep *execProcess) fail(err error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.err == nil {
+		ep.err = err
+	}
+
+	for id, resChan := range ep.pending {
+		close(resChan)
+		delete(ep.pending, id)
+	}
+
+	ep.stdin.Close()
+}
+
+func (ep *execProcess) failure() error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	return ep.err
+}
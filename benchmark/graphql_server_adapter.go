@@ -0,0 +1,236 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var GraphQLConfig struct {
+	Protocol     string
+	InitPayload  string
+	Subscription string
+	Mutation     string
+	Query        string
+}
+
+func init() {
+	flags := pflag.NewFlagSet("graphql", pflag.ContinueOnError)
+	flags.StringVar(&GraphQLConfig.Protocol, "graphql-protocol", GraphQLTransportWS, "GraphQL over WebSocket protocol (graphql-transport-ws, graphql-ws)")
+	flags.StringVar(&GraphQLConfig.InitPayload, "graphql-init-payload", "", "GraphQL connection_init payload (JSON)")
+	flags.StringVar(&GraphQLConfig.Subscription, "graphql-subscription", "subscription { broadcast { sendTime } }", "GraphQL subscription document")
+	flags.StringVar(&GraphQLConfig.Mutation, "graphql-mutation", "mutation($sendTime: String!, $padding: String) { broadcast(sendTime: $sendTime, padding: $padding) { sendTime } }", "GraphQL mutation document to trigger broadcasts")
+	flags.StringVar(&GraphQLConfig.Query, "graphql-query", "query($sendTime: String!, $padding: String) { echo(sendTime: $sendTime, padding: $padding) { sendTime } }", "GraphQL query document to use for echo")
+
+	RegisterServerType(&ServerType{
+		Name:  "graphql",
+		New:   newGraphQLServerAdapter,
+		Flags: flags,
+		Handshake: func(config *websocket.Config) {
+			config.Protocol = []string{GraphQLConfig.Protocol}
+		},
+	})
+}
+
+const (
+	GraphQLTransportWS = "graphql-transport-ws"
+	GraphQLWS          = "graphql-ws"
+)
+
+// GraphQLServerAdapter subscribes every client to the configured subscription
+// and triggers broadcasts by running the mutation; echo runs the query.
+// Operations receive the sendTime and padding variables, and the subscription
+// result is expected to contain the sendTime field somewhere in its data.
+type GraphQLServerAdapter struct {
+	conn *websocket.Conn
+
+	subscriptionID string
+
+	mu      sync.Mutex
+	lastID  int
+	pending map[string]*gqlRequest
+}
+
+type gqlRequest struct {
+	msgType byte
+	payload *Payload
+}
+
+type gqlMsg struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type gqlOperation struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type gqlResult struct {
+	Data   json.RawMessage   `json:"data"`
+	Errors []json.RawMessage `json:"errors"`
+}
+
+func newGraphQLServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	gsa := &GraphQLServerAdapter{conn: conn}
+	if err := gsa.Startup(); err != nil {
+		return nil, err
+	}
+	return gsa, nil
+}
+
+func (gsa *GraphQLServerAdapter) Startup() error {
+	gsa.pending = make(map[string]*gqlRequest)
+
+	init := &gqlMsg{Type: "connection_init"}
+	if GraphQLConfig.InitPayload != "" {
+		init.Payload = json.RawMessage(GraphQLConfig.InitPayload)
+	}
+
+	if err := websocket.JSON.Send(gsa.conn, init); err != nil {
+		return err
+	}
+
+	ack, err := gsa.receiveIgnoringPing()
+	if err != nil {
+		return err
+	}
+
+	if ack.Type != "connection_ack" {
+		return fmt.Errorf("expected connection_ack msg, got %v", ack)
+	}
+
+	gsa.subscriptionID = gsa.nextID()
+
+	return gsa.sendOperation(gsa.subscriptionID, &gqlOperation{Query: GraphQLConfig.Subscription})
+}
+
+func (gsa *GraphQLServerAdapter) SendEcho(payload *Payload) error {
+	return gsa.request(MsgServerEcho, GraphQLConfig.Query, payload)
+}
+
+func (gsa *GraphQLServerAdapter) SendBroadcast(payload *Payload) error {
+	return gsa.request(MsgServerBroadcastResult, GraphQLConfig.Mutation, payload)
+}
+
+func (gsa *GraphQLServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		msg, err := gsa.receiveIgnoringPing()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case "next", "data":
+		case "error", "connection_error":
+			return nil, fmt.Errorf("operation %s failed: %s", msg.ID, msg.Payload)
+		default:
+			// complete and other service messages
+			continue
+		}
+
+		var result gqlResult
+		if err := json.Unmarshal(msg.Payload, &result); err != nil {
+			return nil, err
+		}
+
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("operation %s failed: %s", msg.ID, result.Errors[0])
+		}
+
+		if msg.ID != gsa.subscriptionID {
+			gsa.mu.Lock()
+			req, ok := gsa.pending[msg.ID]
+			delete(gsa.pending, msg.ID)
+			gsa.mu.Unlock()
+
+			if !ok {
+				return nil, fmt.Errorf("unexpected msg, got %v", msg)
+			}
+
+			return &ServerSentMsg{Type: req.msgType, Payload: req.payload}, nil
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(result.Data, &data); err != nil {
+			return nil, err
+		}
+
+		sendTime, ok := findSendTime(data)
+		if !ok {
+			return nil, fmt.Errorf("sendTime is missing in subscription data: %s", result.Data)
+		}
+
+		payload, err := stringToBinaryPayload(sendTime, "")
+		if err != nil {
+			return nil, err
+		}
+
+		return &ServerSentMsg{Type: MsgServerBroadcast, Payload: payload}, nil
+	}
+}
+
+func (gsa *GraphQLServerAdapter) request(msgType byte, query string, payload *Payload) error {
+	id := gsa.nextID()
+
+	gsa.mu.Lock()
+	gsa.pending[id] = &gqlRequest{msgType: msgType, payload: payload}
+	gsa.mu.Unlock()
+
+	return gsa.sendOperation(id, &gqlOperation{
+		Query: query,
+		Variables: map[string]interface{}{
+			"sendTime": strconv.FormatInt(payload.SendTime.UnixNano(), 10),
+			"padding":  string(payload.Padding),
+		},
+	})
+}
+
+func (gsa *GraphQLServerAdapter) sendOperation(id string, op *gqlOperation) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	msgType := "subscribe"
+	if GraphQLConfig.Protocol == GraphQLWS {
+		msgType = "start"
+	}
+
+	return websocket.JSON.Send(gsa.conn, &gqlMsg{ID: id, Type: msgType, Payload: data})
+}
+
+func (gsa *GraphQLServerAdapter) nextID() string {
+	gsa.mu.Lock()
+	defer gsa.mu.Unlock()
+
+	gsa.lastID++
+	return strconv.Itoa(gsa.lastID)
+}
+
+func (gsa *GraphQLServerAdapter) receiveIgnoringPing() (*gqlMsg, error) {
+	for {
+		var msg gqlMsg
+		err := websocket.JSON.Receive(gsa.conn, &msg)
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case "ka", "pong":
+			continue
+		case "ping":
+			if err := websocket.JSON.Send(gsa.conn, &gqlMsg{Type: "pong"}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return &msg, nil
+	}
+}
@@ -0,0 +1,89 @@
+package benchmark
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// HandshakeConfig is applied to the upgrade request of every client.
+// The {{id}} placeholder in the header and cookie values and in the query is
+// replaced with the client ID, so that clients can identify as different users;
+// the client identity fields are also available as placeholders (see Identity).
+var HandshakeConfig struct {
+	Header  http.Header
+	Cookies []string
+	Query   string
+}
+
+// handshakeConfig returns the WebSocket config for the client (connecting to the node if it's set)
+func handshakeConfig(id int, identity Identity, node *clusterNode) (*websocket.Config, error) {
+	config := *RemoteAddr.Config
+
+	location := *RemoteAddr.Config.Location
+	if node != nil {
+		location = *node.location
+	}
+	if identity["url"] != "" {
+		u, err := url.Parse(identity["url"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity URL: %v", err)
+		}
+		location = *u
+	}
+	config.Location = &location
+
+	config.Header = make(http.Header)
+	for k, v := range RemoteAddr.Config.Header {
+		config.Header[k] = v
+	}
+
+	replacer := placeholders(id, identity)
+
+	for k, values := range HandshakeConfig.Header {
+		for _, v := range values {
+			config.Header.Add(k, replacer.Replace(v))
+		}
+	}
+
+	if len(HandshakeConfig.Cookies) > 0 {
+		cookies := make([]string, len(HandshakeConfig.Cookies))
+		for i, cookie := range HandshakeConfig.Cookies {
+			cookies[i] = replacer.Replace(cookie)
+		}
+		config.Header.Add("Cookie", strings.Join(cookies, "; "))
+	}
+
+	query := replacer.Replace(HandshakeConfig.Query)
+
+	if jwtEnabled() {
+		token, err := mintJWT(id, identity)
+		if err != nil {
+			return nil, err
+		}
+
+		if JWTConfig.Header != "" {
+			config.Header.Set(JWTConfig.Header, token)
+		}
+		if JWTConfig.QueryParam != "" {
+			query = appendQuery(query, url.QueryEscape(JWTConfig.QueryParam)+"="+url.QueryEscape(token))
+		}
+	}
+
+	location.RawQuery = appendQuery(location.RawQuery, query)
+
+	return &config, nil
+}
+
+func appendQuery(query, params string) string {
+	if query == "" {
+		return params
+	}
+	if params == "" {
+		return query
+	}
+	return query + "&" + params
+}
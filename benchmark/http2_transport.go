@@ -0,0 +1,763 @@
+package benchmark
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+var HTTP2Config struct {
+	Enabled bool
+	// StreamsPerConn is the max number of clients sharing a TCP connection
+	// (0 to be limited by the server only)
+	StreamsPerConn int
+}
+
+const (
+	// SETTINGS_ENABLE_CONNECT_PROTOCOL (RFC 8441)
+	h2SettingEnableConnectProtocol http2.SettingID = 0x8
+
+	h2InitialWindowSize = 1 << 20
+	h2ConnWindowSize    = 1 << 30
+	// The windows are restored once this much of the received data is consumed
+	h2WindowUpdateThreshold = h2InitialWindowSize / 2
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// h2Pool keeps the HTTP/2 connections by local and remote addresses
+var h2Pool = struct {
+	sync.Mutex
+	conns map[string][]*h2Conn
+}{conns: make(map[string][]*h2Conn)}
+
+// dialH2Stream opens a WebSocket stream over a shared HTTP/2 connection
+// (bootstrapped with the extended CONNECT method, RFC 8441).
+//
+// The stream translates the HTTP/1.1 handshake written by x/net/websocket to
+// the CONNECT request and the response back to the 101 Switching Protocols
+// one, so the clients use it like a regular connection.
+func dialH2Stream(laddr *net.TCPAddr, proxyURL *url.URL, raddr net.Addr, host string, secure bool) (io.ReadWriteCloser, error) {
+	hc := reserveH2Conn(laddr, proxyURL, raddr, host, secure)
+
+	<-hc.ready
+	if hc.err != nil {
+		err := hc.err
+		hc.release()
+		return nil, err
+	}
+
+	s := &h2Stream{conn: hc, secure: secure}
+	s.cond = sync.NewCond(&hc.mu)
+
+	return s, nil
+}
+
+// reserveH2Conn returns a connection with a free stream slot, dialing a new one if needed
+func reserveH2Conn(laddr *net.TCPAddr, proxyURL *url.URL, raddr net.Addr, host string, secure bool) *h2Conn {
+	key := raddr.String()
+	if laddr != nil {
+		key = laddr.String() + "-" + key
+	}
+	if proxyURL != nil {
+		key = proxyURL.String() + "-" + key
+	}
+
+	h2Pool.Lock()
+	defer h2Pool.Unlock()
+
+	for _, hc := range h2Pool.conns[key] {
+		if hc.reserve() {
+			return hc
+		}
+	}
+
+	hc := &h2Conn{
+		key:           key,
+		streams:       make(map[uint32]*h2Stream),
+		nextID:        1,
+		active:        1,
+		sendWindow:    65535,
+		initialWindow: 65535,
+		maxFrameSize:  16384,
+		ready:         make(chan struct{}),
+		done:          make(chan struct{}),
+		controlReady:  make(chan struct{}, 1),
+	}
+	h2Pool.conns[key] = append(h2Pool.conns[key], hc)
+
+	go hc.dial(laddr, proxyURL, raddr, host, secure)
+
+	return hc
+}
+
+type h2Conn struct {
+	key  string
+	conn net.Conn
+
+	// wmu serializes the frame writes and the header compression
+	wmu    sync.Mutex
+	framer *http2.Framer
+	henc   *hpack.Encoder
+	hbuf   bytes.Buffer
+	nextID uint32
+
+	// ready is closed once the server settings are received or dialing fails
+	ready chan struct{}
+	// done is closed once the connection fails
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// control are the control frames written by tx, so that the read loop
+	// never waits for the data writes
+	control      []func() error
+	controlReady chan struct{}
+
+	mu            sync.Mutex
+	err           error
+	streams       map[uint32]*h2Stream
+	active        int
+	maxStreams    uint32
+	sendWindow    int32
+	initialWindow int32
+	maxFrameSize  uint32
+	// recvUnacked is the consumed data the connection window isn't restored for yet
+	recvUnacked uint32
+}
+
+func (hc *h2Conn) reserve() bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hc.err != nil {
+		return false
+	}
+
+	limit := HTTP2Config.StreamsPerConn
+	if hc.maxStreams > 0 && (limit == 0 || int(hc.maxStreams) < limit) {
+		limit = int(hc.maxStreams)
+	}
+
+	if limit > 0 && hc.active >= limit {
+		return false
+	}
+
+	hc.active++
+	return true
+}
+
+func (hc *h2Conn) release() {
+	hc.mu.Lock()
+	hc.active--
+	idle := hc.active == 0 && hc.err != nil
+	hc.mu.Unlock()
+
+	if idle && hc.conn != nil {
+		hc.conn.Close()
+	}
+}
+
+func (hc *h2Conn) dial(laddr *net.TCPAddr, proxyURL *url.URL, raddr net.Addr, host string, secure bool) {
+	err := hc.handshake(laddr, proxyURL, raddr, host, secure)
+	if err != nil {
+		hc.fail(err)
+	}
+	close(hc.ready)
+
+	if err == nil {
+		go hc.tx()
+		hc.rx()
+	}
+}
+
+func (hc *h2Conn) handshake(laddr *net.TCPAddr, proxyURL *url.URL, raddr net.Addr, host string, secure bool) error {
+	tcpConn, err := dialRemote(laddr, proxyURL, raddr, host)
+	if err != nil {
+		return err
+	}
+	hc.conn = tcpConn
+
+	if secure {
+		tlsConn, err := tlsHandshake(tcpConn, host, "h2")
+		if err != nil {
+			return err
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+			tcpConn.Close()
+			return errors.New("server doesn't support HTTP/2")
+		}
+		hc.conn = tlsConn
+	}
+
+	hc.framer = http2.NewFramer(hc.conn, bufio.NewReader(hc.conn))
+	hc.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	hc.henc = hpack.NewEncoder(&hc.hbuf)
+
+	hc.conn.SetDeadline(time.Now().Add(ConnectionTimeout))
+
+	if _, err := io.WriteString(hc.conn, http2.ClientPreface); err != nil {
+		return err
+	}
+
+	err = hc.framer.WriteSettings(
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2InitialWindowSize},
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := hc.framer.WriteWindowUpdate(0, h2ConnWindowSize-65535); err != nil {
+		return err
+	}
+
+	// The server must send its settings first
+	frame, err := hc.framer.ReadFrame()
+	if err != nil {
+		return err
+	}
+
+	settings, ok := frame.(*http2.SettingsFrame)
+	if !ok || settings.IsAck() {
+		return fmt.Errorf("expected server settings, got %v", frame)
+	}
+
+	if err := hc.applySettings(settings); err != nil {
+		return err
+	}
+
+	if v, ok := settings.Value(h2SettingEnableConnectProtocol); !ok || v != 1 {
+		return errors.New("server doesn't support WebSockets over HTTP/2 (RFC 8441)")
+	}
+
+	hc.conn.SetDeadline(time.Time{})
+
+	return nil
+}
+
+func (hc *h2Conn) applySettings(settings *http2.SettingsFrame) error {
+	hc.mu.Lock()
+	err := settings.ForeachSetting(func(s http2.Setting) error {
+		switch s.ID {
+		case http2.SettingMaxConcurrentStreams:
+			hc.maxStreams = s.Val
+		case http2.SettingMaxFrameSize:
+			hc.maxFrameSize = s.Val
+		case http2.SettingInitialWindowSize:
+			delta := int32(s.Val) - hc.initialWindow
+			hc.initialWindow = int32(s.Val)
+			for _, s := range hc.streams {
+				s.sendWindow += delta
+				s.cond.Broadcast()
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		hc.queueControl(func() error { return hc.framer.WriteSettingsAck() })
+	}
+	hc.mu.Unlock()
+
+	return err
+}
+
+// rx dispatches the frames to the streams
+func (hc *h2Conn) rx() {
+	for {
+		frame, err := hc.framer.ReadFrame()
+		if err != nil {
+			hc.fail(err)
+			return
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				err = hc.applySettings(f)
+			}
+		case *http2.MetaHeadersFrame:
+			hc.onHeaders(f)
+		case *http2.DataFrame:
+			hc.onData(f)
+		case *http2.WindowUpdateFrame:
+			hc.onWindowUpdate(f)
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				hc.mu.Lock()
+				hc.queueControl(func() error { return hc.framer.WritePing(true, f.Data) })
+				hc.mu.Unlock()
+			}
+		case *http2.RSTStreamFrame:
+			hc.closeStream(f.StreamID, fmt.Errorf("stream reset by server: %v", f.ErrCode))
+		case *http2.GoAwayFrame:
+			hc.onGoAway(f)
+		}
+
+		if err != nil {
+			hc.fail(err)
+			return
+		}
+	}
+}
+
+func (hc *h2Conn) onHeaders(f *http2.MetaHeadersFrame) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	s, ok := hc.streams[f.StreamID]
+	if !ok {
+		return
+	}
+
+	// Skip informational responses and trailers
+	status := f.PseudoValue("status")
+	if !s.responded && !strings.HasPrefix(status, "1") {
+		response := s.handshakeResponse(status, f.RegularFields())
+		s.rbuf = append(s.rbuf, response...)
+		s.unwindowed = len(response)
+		s.responded = true
+	}
+
+	if f.StreamEnded() {
+		s.err = io.EOF
+	}
+	s.cond.Broadcast()
+}
+
+func (hc *h2Conn) onData(f *http2.DataFrame) {
+	data := f.Data()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	s, ok := hc.streams[f.StreamID]
+	if !ok {
+		// The data of the closed streams is discarded
+		hc.consumed(nil, f.Length)
+		return
+	}
+
+	s.rbuf = append(s.rbuf, data...)
+	if f.StreamEnded() {
+		s.err = io.EOF
+	}
+	s.cond.Broadcast()
+
+	// The padding isn't buffered, so it's consumed right away
+	hc.consumed(s, f.Length-uint32(len(data)))
+}
+
+// consumed restores the flow control windows once enough of the received
+// data is consumed, so the buffered data is limited by the windows; conn.mu must be held
+func (hc *h2Conn) consumed(s *h2Stream, n uint32) {
+	if n == 0 {
+		return
+	}
+
+	hc.recvUnacked += n
+	if hc.recvUnacked >= h2WindowUpdateThreshold {
+		increment := hc.recvUnacked
+		hc.recvUnacked = 0
+		hc.queueControl(func() error { return hc.framer.WriteWindowUpdate(0, increment) })
+	}
+
+	// Closed and ended streams don't receive data anymore
+	if s == nil || s.err != nil {
+		return
+	}
+
+	s.recvUnacked += n
+	if s.recvUnacked >= h2WindowUpdateThreshold {
+		id, increment := s.id, s.recvUnacked
+		s.recvUnacked = 0
+		hc.queueControl(func() error { return hc.framer.WriteWindowUpdate(id, increment) })
+	}
+}
+
+func (hc *h2Conn) onWindowUpdate(f *http2.WindowUpdateFrame) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		hc.sendWindow += int32(f.Increment)
+		for _, s := range hc.streams {
+			s.cond.Broadcast()
+		}
+		return
+	}
+
+	if s, ok := hc.streams[f.StreamID]; ok {
+		s.sendWindow += int32(f.Increment)
+		s.cond.Broadcast()
+	}
+}
+
+// onGoAway stops using the connection for new streams and fails the streams the server won't process
+func (hc *h2Conn) onGoAway(f *http2.GoAwayFrame) {
+	hc.removeFromPool()
+
+	err := fmt.Errorf("connection closed by server: %v", f.ErrCode)
+
+	hc.mu.Lock()
+	if hc.err == nil {
+		hc.err = err
+	}
+	for id, s := range hc.streams {
+		if id > f.LastStreamID {
+			s.fail(err)
+		}
+	}
+	hc.mu.Unlock()
+}
+
+func (hc *h2Conn) fail(err error) {
+	hc.removeFromPool()
+
+	hc.mu.Lock()
+	if hc.err == nil {
+		hc.err = err
+	}
+	for _, s := range hc.streams {
+		s.fail(err)
+	}
+	hc.mu.Unlock()
+
+	if hc.conn != nil {
+		hc.conn.Close()
+	}
+
+	hc.closeOnce.Do(func() { close(hc.done) })
+}
+
+func (hc *h2Conn) removeFromPool() {
+	h2Pool.Lock()
+	defer h2Pool.Unlock()
+
+	conns := h2Pool.conns[hc.key]
+	for i, c := range conns {
+		if c == hc {
+			h2Pool.conns[hc.key] = append(conns[:i:i], conns[i+1:]...)
+			return
+		}
+	}
+}
+
+func (hc *h2Conn) closeStream(id uint32, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if s, ok := hc.streams[id]; ok {
+		s.fail(err)
+	}
+}
+
+// queueControl queues the control frame write for tx; conn.mu must be held
+func (hc *h2Conn) queueControl(fn func() error) {
+	hc.control = append(hc.control, fn)
+
+	select {
+	case hc.controlReady <- struct{}{}:
+	default:
+	}
+}
+
+// tx writes the queued control frames until the connection fails
+func (hc *h2Conn) tx() {
+	for {
+		select {
+		case <-hc.done:
+			return
+		case <-hc.controlReady:
+		}
+
+		hc.mu.Lock()
+		control := hc.control
+		hc.control = nil
+		hc.mu.Unlock()
+
+		for _, fn := range control {
+			if err := hc.write(fn); err != nil {
+				hc.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (hc *h2Conn) write(fn func() error) error {
+	hc.wmu.Lock()
+	defer hc.wmu.Unlock()
+
+	return fn()
+}
+
+// h2Stream is a WebSocket connection over an HTTP/2 stream
+type h2Stream struct {
+	conn   *h2Conn
+	secure bool
+
+	// Guarded by conn.mu
+	id         uint32
+	cond       *sync.Cond
+	rbuf       []byte
+	err        error
+	sendWindow int32
+	responded  bool
+	closed     bool
+	// unwindowed is the size of the handshake response at the start of rbuf,
+	// which isn't flow controlled
+	unwindowed int
+	// recvUnacked is the consumed data the stream window isn't restored for yet
+	recvUnacked uint32
+
+	// Handshake request state (used by the writer only)
+	wbuf          []byte
+	handshakeSent bool
+	key           string
+}
+
+func (s *h2Stream) Read(p []byte) (int, error) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+
+	for len(s.rbuf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.cond.Wait()
+	}
+
+	n := copy(p, s.rbuf)
+	s.rbuf = s.rbuf[n:]
+
+	data := n
+	if s.unwindowed > 0 {
+		skip := s.unwindowed
+		if skip > data {
+			skip = data
+		}
+		s.unwindowed -= skip
+		data -= skip
+	}
+	s.conn.consumed(s, uint32(data))
+
+	return n, nil
+}
+
+func (s *h2Stream) Write(p []byte) (int, error) {
+	if s.handshakeSent {
+		if err := s.writeData(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	s.wbuf = append(s.wbuf, p...)
+
+	end := bytes.Index(s.wbuf, []byte("\r\n\r\n"))
+	if end < 0 {
+		return len(p), nil
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(s.wbuf[:end+4])))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.sendHeaders(req); err != nil {
+		return 0, err
+	}
+
+	rest := s.wbuf[end+4:]
+	s.wbuf = nil
+	s.handshakeSent = true
+
+	if len(rest) > 0 {
+		if err := s.writeData(rest); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *h2Stream) Close() error {
+	hc := s.conn
+
+	hc.mu.Lock()
+	if s.closed {
+		hc.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	// Streams reset by the server are already removed
+	_, open := hc.streams[s.id]
+	s.fail(errors.New("stream closed"))
+	if open && hc.err == nil {
+		id := s.id
+		hc.queueControl(func() error { return hc.framer.WriteRSTStream(id, http2.ErrCodeCancel) })
+	}
+	hc.mu.Unlock()
+
+	hc.release()
+
+	return nil
+}
+
+// fail closes the stream with the error; conn.mu must be held
+func (s *h2Stream) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+	delete(s.conn.streams, s.id)
+	s.cond.Broadcast()
+}
+
+// sendHeaders opens the stream with the extended CONNECT request
+func (s *h2Stream) sendHeaders(req *http.Request) error {
+	hc := s.conn
+
+	s.key = req.Header.Get("Sec-WebSocket-Key")
+
+	scheme := "http"
+	if s.secure {
+		scheme = "https"
+	}
+
+	hc.wmu.Lock()
+	defer hc.wmu.Unlock()
+
+	hc.hbuf.Reset()
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: "CONNECT"},
+		{Name: ":protocol", Value: "websocket"},
+		{Name: ":scheme", Value: scheme},
+		{Name: ":authority", Value: req.Host},
+		{Name: ":path", Value: req.URL.RequestURI()},
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		switch name {
+		case "host", "upgrade", "connection", "sec-websocket-key":
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	for _, f := range fields {
+		if err := hc.henc.WriteField(f); err != nil {
+			return err
+		}
+	}
+
+	hc.mu.Lock()
+	if hc.err != nil {
+		hc.mu.Unlock()
+		return hc.err
+	}
+	s.id = hc.nextID
+	hc.nextID += 2
+	s.sendWindow = hc.initialWindow
+	hc.streams[s.id] = s
+	maxFrameSize := int(hc.maxFrameSize)
+	hc.mu.Unlock()
+
+	block := hc.hbuf.Bytes()
+	first := true
+	for first || len(block) > 0 {
+		chunk := block
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+
+		var err error
+		if first {
+			err = hc.framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      s.id,
+				BlockFragment: chunk,
+				EndHeaders:    len(block) == 0,
+			})
+			first = false
+		} else {
+			err = hc.framer.WriteContinuation(s.id, len(block) == 0, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeData sends the data respecting the flow control windows
+func (s *h2Stream) writeData(p []byte) error {
+	hc := s.conn
+
+	for len(p) > 0 {
+		hc.mu.Lock()
+		for s.err == nil && (hc.sendWindow <= 0 || s.sendWindow <= 0) {
+			s.cond.Wait()
+		}
+		if s.err != nil {
+			err := s.err
+			hc.mu.Unlock()
+			return err
+		}
+
+		n := len(p)
+		for _, limit := range []int{int(hc.sendWindow), int(s.sendWindow), int(hc.maxFrameSize)} {
+			if n > limit {
+				n = limit
+			}
+		}
+		hc.sendWindow -= int32(n)
+		s.sendWindow -= int32(n)
+		hc.mu.Unlock()
+
+		if err := hc.write(func() error { return hc.framer.WriteData(s.id, false, p[:n]) }); err != nil {
+			return err
+		}
+
+		p = p[n:]
+	}
+
+	return nil
+}
+
+// handshakeResponse translates the CONNECT response to the HTTP/1.1 handshake response
+func (s *h2Stream) handshakeResponse(status string, fields []hpack.HeaderField) []byte {
+	var b bytes.Buffer
+
+	if status != "200" {
+		fmt.Fprintf(&b, "HTTP/1.1 %s Unexpected CONNECT Status\r\n\r\n", status)
+		return b.Bytes()
+	}
+
+	accept := sha1.Sum([]byte(s.key + websocketGUID))
+
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\n")
+	b.WriteString("Connection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n")
+	for _, f := range fields {
+		b.WriteString(http.CanonicalHeaderKey(f.Name) + ": " + f.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+
+	return b.Bytes()
+}
@@ -0,0 +1,167 @@
+package benchmark
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Identity is the per-client data read from the identities file.
+//
+// Every field is available as a {{field}} placeholder in the handshake
+// headers, cookies and query and in the JWT claims. The "url" field overrides
+// the URL the client connects to, and the "channel" field overrides the
+// Action Cable channel identifier.
+type Identity map[string]string
+
+// Identity order modes
+const (
+	// IdentityOrderSequential uses every identity once, clients fail when they're exhausted
+	IdentityOrderSequential = "sequential"
+	// IdentityOrderRoundRobin starts over when the identities are exhausted
+	IdentityOrderRoundRobin = "round-robin"
+)
+
+var identities struct {
+	sync.Mutex
+	list  []Identity
+	order string
+	next  int
+}
+
+// LoadIdentities reads the identities file: CSV with a header row if the file
+// has the .csv extension, or JSON lines (one object per line) otherwise
+func LoadIdentities(path, order string) error {
+	if order != IdentityOrderSequential && order != IdentityOrderRoundRobin {
+		return fmt.Errorf("unknown identity order: %s", order)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var list []Identity
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		list, err = readCSVIdentities(f)
+	} else {
+		list, err = readJSONLinesIdentities(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read identities from %s: %v", path, err)
+	}
+
+	if len(list) == 0 {
+		return fmt.Errorf("no identities found in %s", path)
+	}
+
+	identities.Lock()
+	defer identities.Unlock()
+
+	identities.list = list
+	identities.order = order
+	identities.next = 0
+
+	return nil
+}
+
+// nextIdentity returns the identity for the next client (nil if no identities are loaded)
+func nextIdentity() (Identity, error) {
+	identities.Lock()
+	defer identities.Unlock()
+
+	if len(identities.list) == 0 {
+		return nil, nil
+	}
+
+	if identities.next >= len(identities.list) {
+		if identities.order != IdentityOrderRoundRobin {
+			return nil, errors.New("identities exhausted")
+		}
+		identities.next = 0
+	}
+
+	identity := identities.list[identities.next]
+	identities.next++
+
+	return identity, nil
+}
+
+// placeholders returns the replacer of the {{id}} and identity field placeholders
+func placeholders(id int, identity Identity) *strings.Replacer {
+	pairs := []string{"{{id}}", strconv.Itoa(id)}
+	for k, v := range identity {
+		pairs = append(pairs, "{{"+k+"}}", v)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func readCSVIdentities(r io.Reader) ([]Identity, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Identity
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		identity := make(Identity, len(header))
+		for i, name := range header {
+			identity[strings.TrimSpace(name)] = record[i]
+		}
+		list = append(list, identity)
+	}
+}
+
+func readJSONLinesIdentities(r io.Reader) ([]Identity, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var list []Identity
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		identity := make(Identity, len(fields))
+		for k, v := range fields {
+			if s, ok := v.(string); ok {
+				identity[k] = s
+				continue
+			}
+
+			// Non-string values (e.g. channel params objects) are kept as JSON
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			identity[k] = string(data)
+		}
+		list = append(list, identity)
+	}
+
+	return list, scanner.Err()
+}
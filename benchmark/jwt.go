@@ -0,0 +1,199 @@
+package benchmark
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// JWTOptions configure the identification tokens minted for every client.
+// Tokens are minted when a secret (HS256) or a private key file (RS256) is set.
+type JWTOptions struct {
+	Algorithm string
+	Secret    string
+	KeyFile   string
+	// Claims is the JSON claims template, {{id}} is replaced with the client ID
+	// (unique within the run, so every client gets its own token) and the
+	// identity placeholders with the client identity fields
+	Claims string
+	// TTL is the token lifetime used to set the exp claim (0 to omit it)
+	TTL time.Duration
+	// Expired makes the tokens expired by TTL at the time they're minted
+	Expired bool
+	// QueryParam and Header are where the token is passed during the handshake
+	QueryParam string
+	Header     string
+}
+
+var JWTConfig JWTOptions
+
+var jwtRSAKey *rsa.PrivateKey
+
+func jwtEnabled() bool {
+	return JWTConfig.Secret != "" || JWTConfig.KeyFile != ""
+}
+
+// ConfigureJWT validates the JWT options and loads the signing key
+func ConfigureJWT() error {
+	jwtRSAKey = nil
+
+	if !jwtEnabled() {
+		return nil
+	}
+
+	switch JWTConfig.Algorithm {
+	case "HS256":
+		if JWTConfig.Secret == "" {
+			return errors.New("JWT secret is required for HS256")
+		}
+	case "RS256":
+		if JWTConfig.KeyFile == "" {
+			return errors.New("JWT key file is required for RS256")
+		}
+
+		key, err := loadRSAPrivateKey(JWTConfig.KeyFile)
+		if err != nil {
+			return err
+		}
+		jwtRSAKey = key
+	default:
+		return fmt.Errorf("unsupported JWT algorithm: %s", JWTConfig.Algorithm)
+	}
+
+	if JWTConfig.QueryParam == "" && JWTConfig.Header == "" {
+		return errors.New("JWT query parameter or header is required")
+	}
+
+	if JWTConfig.Expired && JWTConfig.TTL <= 0 {
+		return errors.New("JWT TTL is required for expired tokens")
+	}
+
+	if _, err := jwtClaims(0, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// mintJWT returns the signed token identifying the client
+func mintJWT(clientID int, identity Identity) (string, error) {
+	claims, err := jwtClaims(clientID, identity)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": JWTConfig.Algorithm, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	var signature []byte
+	if jwtRSAKey != nil {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, jwtRSAKey, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	} else {
+		mac := hmac.New(sha256.New, []byte(JWTConfig.Secret))
+		mac.Write([]byte(unsigned))
+		signature = mac.Sum(nil)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func jwtClaims(clientID int, identity Identity) (map[string]interface{}, error) {
+	claims := make(map[string]interface{})
+
+	if JWTConfig.Claims != "" {
+		decoder := json.NewDecoder(strings.NewReader(JWTConfig.Claims))
+		decoder.UseNumber()
+		if err := decoder.Decode(&claims); err != nil {
+			return nil, fmt.Errorf("invalid JWT claims template: %v", err)
+		}
+
+		// The placeholders are replaced in the parsed strings, so the identity
+		// fields with quotes or backslashes can't break the claims JSON
+		replacer := placeholders(clientID, identity)
+		for k, v := range claims {
+			claims[k] = replaceClaimPlaceholders(replacer, v)
+		}
+	}
+
+	if JWTConfig.TTL > 0 {
+		now := time.Now()
+		if JWTConfig.Expired {
+			now = now.Add(-2 * JWTConfig.TTL)
+		}
+
+		claims["iat"] = now.Unix()
+		claims["exp"] = now.Add(JWTConfig.TTL).Unix()
+	}
+
+	return claims, nil
+}
+
+// replaceClaimPlaceholders replaces the placeholders in the strings of the claim value
+func replaceClaimPlaceholders(replacer *strings.Replacer, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return replacer.Replace(v)
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = replaceClaimPlaceholders(replacer, item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = replaceClaimPlaceholders(replacer, item)
+		}
+	}
+
+	return value
+}
+
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
@@ -1,23 +1,19 @@
 package benchmark
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/websocket"
 )
 
-var RemoteAddr struct {
-	Addr   *net.TCPAddr
-	Host   string
-	Config *websocket.Config
-	Secure bool
-}
-
 const (
 	MsgServerEcho            = 'e'
 	MsgServerBroadcast       = 'b'
@@ -30,6 +26,10 @@ type localClient struct {
 	conn           *websocket.Conn
 	config         *websocket.Config
 	laddr          *net.TCPAddr
+	proxy          *url.URL
+	raddr          net.Addr
+	host           string
+	secure         bool
 	dest           string
 	origin         string
 	serverType     string
@@ -38,14 +38,32 @@ type localClient struct {
 	errChan        chan<- error
 	payloadPadding []byte
 
+	// node is the cluster node the client is assigned to
+	node *clusterNode
+	// addrLabel names the remote address (or the node) in the per-address metrics
+	addrLabel string
+
 	rxBroadcastCountLock sync.Mutex
 	rxBroadcastCount     int
+
+	resumeMu sync.Mutex
+	resumed  chan bool
 }
 
 type ServerAdapter interface {
 	SendEcho(payload *Payload) error
 	SendBroadcast(payload *Payload) error
-	Receive() (*serverSentMsg, error)
+	Receive() (*ServerSentMsg, error)
+}
+
+// sessionRecoverer is implemented by server adapters which can restore
+// their session over a new connection
+type sessionRecoverer interface {
+	// RestoreHeader returns the handshake headers to restore the session with
+	RestoreHeader() http.Header
+	// Restore switches the adapter to the new connection; the returned channel
+	// receives the result once the session and its streams are restored
+	Restore(conn *websocket.Conn) <-chan error
 }
 
 type Payload struct {
@@ -58,8 +76,8 @@ type jsonPayload struct {
 	Padding  interface{} `json:"padding,omitempty"`
 }
 
-// serverSentMsg includes all fields that can be in server sent message
-type serverSentMsg struct {
+// ServerSentMsg includes all fields that can be in server sent message
+type ServerSentMsg struct {
 	Type          byte
 	Payload       *Payload
 	ListenerCount int
@@ -72,7 +90,10 @@ type jsonServerSentMsg struct {
 }
 
 func newLocalClient(
+	id int,
+	identity Identity,
 	laddr *net.TCPAddr,
+	proxy *url.URL,
 	dest, origin, serverType string,
 	rttResultChan chan<- time.Duration,
 	errChan chan error,
@@ -84,6 +105,10 @@ func newLocalClient(
 
 	c := &localClient{
 		laddr:          laddr,
+		proxy:          proxy,
+		raddr:          nextRemoteAddr(),
+		host:           RemoteAddr.Host,
+		secure:         RemoteAddr.Secure,
 		dest:           dest,
 		origin:         origin,
 		rttResultChan:  rttResultChan,
@@ -91,68 +116,185 @@ func newLocalClient(
 		payloadPadding: padding,
 	}
 
-	tcpConn, err := net.DialTCP("tcp", c.laddr, RemoteAddr.Addr)
+	c.node = pickNode(id)
+
+	var err error
+	c.config, err = handshakeConfig(id, identity, c.node)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	var conn io.ReadWriteCloser
-
-	if RemoteAddr.Secure {
-		conn = tls.Client(tcpConn, &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         RemoteAddr.Host,
-		})
-	} else {
-		conn = tcpConn
+	if c.node != nil {
+		c.raddr, c.host, c.secure = c.node.raddr, c.node.host, c.node.secure
 	}
 
-	initTime := time.Now()
+	phases := newPhases()
 
-	c.conn, err = websocket.NewClient(RemoteAddr.Config, conn)
+	// The identity may point the client to another URL
+	if identity["url"] != "" {
+		c.raddr, c.host, err = ResolveRemoteAddr(c.config.Location.Host)
+		if err != nil {
+			return nil, err
+		}
+		c.secure = c.config.Location.Scheme == "wss"
+		phases.Mark("dns")
+	}
+
+	conn, initTime, err := c.dialTransport(phases)
 	if err != nil {
 		return nil, err
 	}
 
+	c.addrLabel = remoteAddrLabel(c.raddr)
+	if c.node != nil {
+		c.addrLabel = c.node.name
+	}
+
+	uc := &upgradeConn{ReadWriteCloser: conn}
+	c.conn, err = websocket.NewClient(c.config, uc)
+	if err != nil {
+		return nil, uc.upgradeError(err)
+	}
+	phases.Mark("upgrade")
+
 	c.conn.MaxPayloadBytes = 1000000
 
-	switch serverType {
-	case "json":
-		c.serverAdapter = &StandardServerAdapter{conn: c.conn}
-	case "binary":
-		c.serverAdapter = &BinaryServerAdapter{conn: c.conn}
-	case "actioncable":
-		acsa := &ActionCableServerAdapter{conn: c.conn}
-		err = acsa.Startup()
+	st, err := LookupServerType(serverType)
+	if err != nil {
+		return nil, err
+	}
+
+	c.serverAdapter, err = st.New(c.conn, &ClientInfo{ID: id, InitTime: initTime, Identity: identity, Phases: phases})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.addrLabel != "" {
+		stepMetrics.AddGauge("connections@"+c.addrLabel, 1)
+	}
+	if c.node != nil {
+		atomic.AddInt32(&c.node.clients, 1)
+	}
+
+	go c.rx()
+
+	return c, nil
+}
+
+// dialTransport opens a TCP or Unix socket (or TLS) connection to the remote address,
+// or a stream over a shared HTTP/2 connection.
+//
+// It also returns the time the connection started to be initialized: the
+// connect benchmark duration includes the TLS handshake and the upgrade,
+// but not the TCP connect.
+func (c *localClient) dialTransport(phases *Phases) (io.ReadWriteCloser, time.Time, error) {
+	var conn io.ReadWriteCloser
+	var initTime time.Time
+
+	if HTTP2Config.Enabled {
+		stream, err := dialH2Stream(c.laddr, c.proxy, c.raddr, c.host, c.secure)
 		if err != nil {
-			return nil, err
+			return nil, initTime, err
 		}
-		c.serverAdapter = acsa
-	case "actioncable-connect":
-		acsa := &ActionCableServerConnectAdapter{conn: c.conn}
-		err = acsa.Startup()
+		conn = stream
+		phases.Mark("h2_stream")
+		initTime = time.Now()
+	} else {
+		tcpConn, err := dialRemote(c.laddr, c.proxy, c.raddr, c.host)
 		if err != nil {
-			return nil, err
+			return nil, initTime, err
 		}
-		err = acsa.Connected(initTime)
-		if err != nil {
-			return nil, err
+		phases.Mark("tcp_connect")
+		initTime = time.Now()
+
+		conn = tcpConn
+		if c.secure {
+			tlsConn, err := tlsHandshake(tcpConn, c.host)
+			if err != nil {
+				return nil, initTime, err
+			}
+			conn = tlsConn
+			phases.Skip()
 		}
-		c.serverAdapter = acsa
-	case "phoenix":
-		psa := &PhoenixServerAdapter{conn: c.conn}
-		err = psa.Startup()
+	}
+
+	if useFrameConn() {
+		conn = newFrameConn(conn)
+	}
+
+	return conn, initTime, nil
+}
+
+// Reconnect drops the connection and restores the session over a new one.
+// It returns the time from the drop until the session is restored.
+func (c *localClient) Reconnect() (time.Duration, error) {
+	recoverer, ok := c.serverAdapter.(sessionRecoverer)
+	if !ok {
+		return 0, errors.New("server type doesn't support session recovery")
+	}
+
+	resumed := make(chan bool, 1)
+
+	c.resumeMu.Lock()
+	c.resumed = resumed
+	c.resumeMu.Unlock()
+
+	dropTime := time.Now()
+
+	c.conn.Close()
+
+	transport, _, err := c.dialTransport(nil)
+	if err != nil {
+		resumed <- false
+		return 0, err
+	}
+
+	config := *c.config
+	config.Header = make(http.Header)
+	for k, v := range c.config.Header {
+		config.Header[k] = v
+	}
+	for k, v := range recoverer.RestoreHeader() {
+		config.Header[k] = v
+	}
+
+	conn, err := websocket.NewClient(&config, transport)
+	if err != nil {
+		resumed <- false
+		return 0, err
+	}
+
+	conn.MaxPayloadBytes = 1000000
+
+	c.conn = conn
+	restored := recoverer.Restore(conn)
+	resumed <- true
+
+	select {
+	case err := <-restored:
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		c.serverAdapter = psa
-	default:
-		return nil, fmt.Errorf("Unknown server type: %v", serverType)
+	case <-time.After(ConnectionTimeout):
+		return 0, errors.New("Session recovery timeout exceeded")
 	}
 
-	go c.rx()
+	return time.Since(dropTime), nil
+}
 
-	return c, nil
+// waitResume returns true if the connection has been dropped by Reconnect
+// and the new one is ready to receive messages
+func (c *localClient) waitResume() bool {
+	c.resumeMu.Lock()
+	resumed := c.resumed
+	c.resumed = nil
+	c.resumeMu.Unlock()
+
+	if resumed == nil {
+		return false
+	}
+
+	return <-resumed
 }
 
 func (c *localClient) SendEcho() error {
@@ -160,7 +302,11 @@ func (c *localClient) SendEcho() error {
 }
 
 func (c *localClient) SendBroadcast() error {
-	return c.serverAdapter.SendBroadcast(&Payload{SendTime: time.Now(), Padding: c.payloadPadding})
+	payload := &Payload{SendTime: time.Now(), Padding: c.payloadPadding}
+	if c.node != nil {
+		trackBroadcastSent(c.node, payload)
+	}
+	return c.serverAdapter.SendBroadcast(payload)
 }
 
 func (c *localClient) ResetRxBroadcastCount() (int, error) {
@@ -172,9 +318,19 @@ func (c *localClient) ResetRxBroadcastCount() (int, error) {
 }
 
 func (c *localClient) rx() {
+	if c.addrLabel != "" {
+		defer stepMetrics.AddGauge("connections@"+c.addrLabel, -1)
+	}
+	if c.node != nil {
+		defer atomic.AddInt32(&c.node.clients, -1)
+	}
+
 	for {
 		msg, err := c.serverAdapter.Receive()
 		if err != nil {
+			if c.waitResume() {
+				continue
+			}
 			c.errChan <- err
 			return
 		}
@@ -183,12 +339,18 @@ func (c *localClient) rx() {
 		case MsgServerEcho, MsgServerBroadcastResult:
 			if msg.Payload != nil {
 				rtt := time.Now().Sub(msg.Payload.SendTime)
+				if c.addrLabel != "" {
+					stepMetrics.AddLatency("rtt@"+c.addrLabel, rtt)
+				}
 				c.rttResultChan <- rtt
 			} else {
-				c.errChan <- fmt.Errorf("received unparsable %s payload: %v", msg.Type, msg.Payload)
+				c.errChan <- fmt.Errorf("received unparsable %c payload: %v", msg.Type, msg.Payload)
 				return
 			}
 		case MsgServerBroadcast:
+			if c.node != nil && msg.Payload != nil {
+				trackBroadcastReceived(c.node, msg.Payload)
+			}
 			c.rxBroadcastCountLock.Lock()
 			c.rxBroadcastCount++
 			c.rxBroadcastCountLock.Unlock()
@@ -201,6 +363,7 @@ func (c *localClient) rx() {
 
 type LocalClientPool struct {
 	laddr   *net.TCPAddr
+	proxy   *url.URL
 	clients map[int]*localClient
 	mu      sync.Mutex
 }
@@ -212,6 +375,17 @@ func NewLocalClientPool(laddr *net.TCPAddr) *LocalClientPool {
 	}
 }
 
+// UseProxy makes the pool clients connect through the HTTP CONNECT or SOCKS5 proxy
+func (lcp *LocalClientPool) UseProxy(proxyURL string) error {
+	u, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return err
+	}
+
+	lcp.proxy = u
+	return nil
+}
+
 func (lcp *LocalClientPool) New(
 	id int,
 	dest, origin, serverType string,
@@ -219,7 +393,24 @@ func (lcp *LocalClientPool) New(
 	errChan chan error,
 	padding []byte,
 ) (Client, error) {
-	c, err := newLocalClient(lcp.laddr, dest, origin, serverType, rttResultChan, errChan, padding)
+	identity, err := nextIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	return lcp.newClient(id, identity, dest, origin, serverType, rttResultChan, errChan, padding)
+}
+
+// newClient creates the client with the given identity (e.g. forwarded to the worker)
+func (lcp *LocalClientPool) newClient(
+	id int,
+	identity Identity,
+	dest, origin, serverType string,
+	rttResultChan chan time.Duration,
+	errChan chan error,
+	padding []byte,
+) (Client, error) {
+	c, err := newLocalClient(id, identity, lcp.laddr, lcp.proxy, dest, origin, serverType, rttResultChan, errChan, padding)
 	if err != nil {
 		return nil, err
 	}
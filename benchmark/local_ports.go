@@ -0,0 +1,123 @@
+package benchmark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LocalPortRanges are the source port ranges the clients bind to on every
+// local address. If there are none, the kernel picks the ephemeral ports.
+var LocalPortRanges []PortRange
+
+type PortRange struct {
+	From int
+	To   int
+}
+
+func (r PortRange) size() int {
+	return r.To - r.From + 1
+}
+
+// ParsePortRange parses the "from-to" port range
+func ParsePortRange(s string) (PortRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return PortRange{}, fmt.Errorf("invalid port range: %s", s)
+	}
+
+	from, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range: %s", s)
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range: %s", s)
+	}
+
+	if from == 0 || from > to {
+		return PortRange{}, fmt.Errorf("invalid port range: %s", s)
+	}
+
+	return PortRange{From: int(from), To: int(to)}, nil
+}
+
+// LocalAddrCapacity returns the max number of connections from the local
+// addresses (0 if unknown). The kernel picked ports can be reused for other
+// destinations unless the clients bind to the local addresses.
+func LocalAddrCapacity(localIPs, destinations int) int {
+	ports := 0
+	for _, r := range LocalPortRanges {
+		ports += r.size()
+	}
+	if ports > 0 {
+		if localIPs == 0 {
+			return ports
+		}
+		return localIPs * ports
+	}
+
+	ports = ephemeralPortCount()
+	if localIPs > 0 {
+		return localIPs * ports
+	}
+	return ports * destinations
+}
+
+// ephemeralPortCount reads the kernel ephemeral ports range (0 if unknown)
+func ephemeralPortCount() int {
+	data, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0
+	}
+
+	from, err1 := strconv.Atoi(fields[0])
+	to, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || from > to {
+		return 0
+	}
+
+	return to - from + 1
+}
+
+// portPlanners hand out the source ports by local IP
+var portPlanners = struct {
+	sync.Mutex
+	next map[string]int
+}{next: make(map[string]int)}
+
+// nextLocalAddr returns the local address bound to the next free source port
+func nextLocalAddr(laddr *net.TCPAddr) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{}
+	if laddr != nil {
+		addr.IP = laddr.IP
+		addr.Zone = laddr.Zone
+	}
+	key := "*"
+	if addr.IP != nil {
+		key = addr.IP.String()
+	}
+
+	portPlanners.Lock()
+	n := portPlanners.next[key]
+	portPlanners.next[key]++
+	portPlanners.Unlock()
+
+	for _, r := range LocalPortRanges {
+		if n < r.size() {
+			addr.Port = r.From + n
+			return addr, nil
+		}
+		n -= r.size()
+	}
+
+	return nil, classify(ErrorClassLocalPortsExhausted, fmt.Errorf("local ports exhausted on %s", key))
+}
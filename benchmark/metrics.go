@@ -0,0 +1,146 @@
+package benchmark
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// stepMetrics collects auxiliary measurements reported by clients and server
+// adapters (e.g. heartbeat latencies) in addition to the main RTT samples.
+// The benchmarks take a snapshot at the end of each step.
+var stepMetrics = newMetricsCollector()
+
+type metricsCollector struct {
+	mu        sync.Mutex
+	latencies map[string]*rttAggregate
+	counters  map[string]int
+	// precise are the latencies reported with sub-millisecond precision
+	precise map[string]bool
+	// gauges are reported as counters but kept between the steps
+	gauges map[string]int
+}
+
+// StepMetrics is a snapshot of the auxiliary metrics collected during a step
+type StepMetrics struct {
+	LimitPercentile int
+	Latencies       []LatencyMetric
+	Counters        []CounterMetric
+}
+
+type LatencyMetric struct {
+	Name string
+	// Precise latencies are reported with sub-millisecond precision
+	Precise    bool
+	Count      int
+	Percentile time.Duration
+	Min        time.Duration
+	Median     time.Duration
+	Max        time.Duration
+}
+
+type CounterMetric struct {
+	Name  string
+	Value int
+}
+
+func newMetricsCollector() *metricsCollector {
+	return &metricsCollector{
+		latencies: make(map[string]*rttAggregate),
+		counters:  make(map[string]int),
+		precise:   make(map[string]bool),
+		gauges:    make(map[string]int),
+	}
+}
+
+func (mc *metricsCollector) AddLatency(name string, d time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	agg, ok := mc.latencies[name]
+	if !ok {
+		agg = &rttAggregate{}
+		mc.latencies[name] = agg
+	}
+	agg.Add(d)
+}
+
+// AddPreciseLatency adds the latency reported with sub-millisecond precision
+// (e.g. the handshake phases, which take less than a millisecond on a LAN)
+func (mc *metricsCollector) AddPreciseLatency(name string, d time.Duration) {
+	mc.AddLatency(name, d)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.precise[name] = true
+}
+
+func (mc *metricsCollector) Add(name string, delta int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.counters[name] += delta
+}
+
+// AddGauge changes the gauge value (e.g. the number of open connections)
+func (mc *metricsCollector) AddGauge(name string, delta int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.gauges[name] += delta
+}
+
+// Snapshot returns the metrics collected since the previous snapshot and resets them
+func (mc *metricsCollector) Snapshot(limitPercentile int) *StepMetrics {
+	mc.mu.Lock()
+	latencies, counters, precise := mc.latencies, mc.counters, mc.precise
+	mc.latencies = make(map[string]*rttAggregate)
+	mc.counters = make(map[string]int)
+	mc.precise = make(map[string]bool)
+	for name, value := range mc.gauges {
+		counters[name] = value
+	}
+	mc.mu.Unlock()
+
+	metrics := &StepMetrics{LimitPercentile: limitPercentile}
+
+	for name, agg := range latencies {
+		metrics.Latencies = append(metrics.Latencies, LatencyMetric{
+			Name:       name,
+			Precise:    precise[name],
+			Count:      agg.Count(),
+			Percentile: agg.Percentile(limitPercentile),
+			Min:        agg.Min(),
+			Median:     agg.Percentile(50),
+			Max:        agg.Max(),
+		})
+	}
+	sort.Slice(metrics.Latencies, func(i, j int) bool { return metrics.Latencies[i].Name < metrics.Latencies[j].Name })
+
+	// The share of the resumed TLS sessions, in percent
+	if handshakes := counters["tls_handshakes"]; handshakes > 0 {
+		counters["tls_resumed_percent"] = counters["tls_resumed"] * 100 / handshakes
+	}
+
+	for name, value := range counters {
+		metrics.Counters = append(metrics.Counters, CounterMetric{Name: name, Value: value})
+	}
+	sort.Slice(metrics.Counters, func(i, j int) bool { return metrics.Counters[i].Name < metrics.Counters[j].Name })
+
+	return metrics
+}
+
+func (sm *StepMetrics) Empty() bool {
+	return len(sm.Latencies) == 0 && len(sm.Counters) == 0
+}
+
+// recordStepMetrics passes the metrics collected during the step to the recorder
+func recordStepMetrics(recorder ResultRecorder, limitPercentile int) error {
+	metrics := stepMetrics.Snapshot(limitPercentile)
+	if metrics.Empty() {
+		return nil
+	}
+
+	return recorder.RecordMetrics(metrics)
+}
@@ -0,0 +1,473 @@
+package benchmark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var MQTTConfig struct {
+	Version   int
+	Topic     string
+	QoS       int
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+}
+
+// mqttVersion is the --mqtt-version flag value
+var mqttVersion string
+
+func init() {
+	flags := pflag.NewFlagSet("mqtt", pflag.ContinueOnError)
+	flags.StringVar(&mqttVersion, "mqtt-version", "3.1.1", "MQTT protocol version (3.1.1, 5)")
+	flags.StringVar(&MQTTConfig.Topic, "mqtt-topic", "benchmark", "MQTT topic to subscribe and publish broadcasts to")
+	flags.IntVar(&MQTTConfig.QoS, "mqtt-qos", 0, "MQTT QoS level for subscriptions and publications (0, 1, 2)")
+	flags.StringVar(&MQTTConfig.Username, "mqtt-username", "", "MQTT username")
+	flags.StringVar(&MQTTConfig.Password, "mqtt-password", "", "MQTT password")
+	flags.DurationVar(&MQTTConfig.KeepAlive, "mqtt-keepalive", time.Minute, "MQTT keep alive interval (0 to disable)")
+
+	RegisterServerType(&ServerType{
+		Name:      "mqtt",
+		New:       newMQTTServerAdapter,
+		Flags:     flags,
+		Configure: configureMQTT,
+		Handshake: func(config *websocket.Config) {
+			config.Protocol = []string{"mqtt"}
+		},
+	})
+}
+
+func configureMQTT() error {
+	switch mqttVersion {
+	case "3.1.1":
+		MQTTConfig.Version = 4
+	case "5":
+		MQTTConfig.Version = 5
+	default:
+		return fmt.Errorf("unsupported MQTT version: %s", mqttVersion)
+	}
+
+	if MQTTConfig.QoS < 0 || MQTTConfig.QoS > 2 {
+		return fmt.Errorf("invalid MQTT QoS: %d", MQTTConfig.QoS)
+	}
+
+	return nil
+}
+
+// MQTT control packet types (shifted into the high nibble)
+const (
+	mqttConnect     = 0x10
+	mqttConnack     = 0x20
+	mqttPublish     = 0x30
+	mqttPuback      = 0x40
+	mqttPubrec      = 0x50
+	mqttPubrel      = 0x60
+	mqttPubcomp     = 0x70
+	mqttSubscribe   = 0x80
+	mqttSuback      = 0x90
+	mqttPingreq     = 0xc0
+	mqttPingresp    = 0xd0
+	mqttDisconnect  = 0xe0
+	mqttPacketTypes = 0xf0
+)
+
+// MQTTServerAdapter speaks MQTT 3.1.1 or 5 over WebSocket.
+//
+// Every client subscribes to the configured topic and to its own echo topic.
+// Messages carry a JSON body with an action ("echo" or "broadcast"), the
+// sender client ID and the payload; a client receiving its own broadcast
+// reports it both as a broadcast and as the broadcast result.
+type MQTTServerAdapter struct {
+	conn     *websocket.Conn
+	reader   *bufio.Reader
+	clientID string
+
+	mu           sync.Mutex
+	lastPacketID uint16
+
+	queue []*ServerSentMsg
+	done  chan struct{}
+}
+
+type mqttPacket struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+type mqttMsg struct {
+	Action  string       `json:"action"`
+	Client  string       `json:"client,omitempty"`
+	Payload *jsonPayload `json:"payload"`
+}
+
+func newMQTTServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	msa := &MQTTServerAdapter{conn: conn}
+	if err := msa.Startup(); err != nil {
+		return nil, err
+	}
+	return msa, nil
+}
+
+func (msa *MQTTServerAdapter) Startup() error {
+	msa.reader = bufio.NewReader(msa.conn)
+	msa.clientID = "websocket-bench-" + strconv.FormatInt(rand.Int63(), 36)
+	msa.done = make(chan struct{})
+
+	if err := msa.connect(); err != nil {
+		return err
+	}
+
+	if MQTTConfig.KeepAlive > 0 {
+		go msa.keepAlive(MQTTConfig.KeepAlive)
+	}
+
+	packetID := msa.nextPacketID()
+
+	var body []byte
+	body = mqttAppendUint16(body, packetID)
+	body = msa.appendProperties(body)
+	for _, topic := range []string{MQTTConfig.Topic, msa.echoTopic()} {
+		body = mqttAppendString(body, topic)
+		body = append(body, byte(MQTTConfig.QoS))
+	}
+
+	if err := msa.send(mqttSubscribe|0x02, body); err != nil {
+		return err
+	}
+
+	for {
+		packet, err := msa.receivePacket()
+		if err != nil {
+			return err
+		}
+
+		if packet.Type != mqttSuback {
+			continue
+		}
+
+		if len(packet.Body) < 2 {
+			return errors.New("malformed SUBACK packet")
+		}
+
+		body := packet.Body[2:]
+		if MQTTConfig.Version == 5 {
+			body, err = mqttSkipProperties(body)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, code := range body {
+			if code >= 0x80 {
+				return classify(ErrorClassSubscriptionRejected, fmt.Errorf("subscription rejected with reason code 0x%02x", code))
+			}
+		}
+
+		return nil
+	}
+}
+
+func (msa *MQTTServerAdapter) SendEcho(payload *Payload) error {
+	return msa.publish(msa.echoTopic(), "echo", payload)
+}
+
+func (msa *MQTTServerAdapter) SendBroadcast(payload *Payload) error {
+	return msa.publish(MQTTConfig.Topic, "broadcast", payload)
+}
+
+func (msa *MQTTServerAdapter) Receive() (*ServerSentMsg, error) {
+	if len(msa.queue) > 0 {
+		msg := msa.queue[0]
+		msa.queue = msa.queue[1:]
+		return msg, nil
+	}
+
+	for {
+		packet, err := msa.receivePacket()
+		if err != nil {
+			return nil, err
+		}
+
+		switch packet.Type {
+		case mqttPublish:
+		case mqttPubrec, mqttPubrel:
+			if err := msa.acknowledge(packet); err != nil {
+				return nil, err
+			}
+			continue
+		case mqttDisconnect:
+			return nil, errors.New("disconnected by server")
+		default:
+			continue
+		}
+
+		data, err := msa.handlePublish(packet)
+		if err != nil {
+			return nil, err
+		}
+
+		var msg mqttMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+
+		if msg.Payload == nil {
+			return nil, fmt.Errorf("received message without payload: %s", data)
+		}
+
+		msgType, err := ParseMessageType(msg.Action)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := jsonPayloadToPayload(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if msgType == MsgServerBroadcast && msg.Client == msa.clientID {
+			msa.queue = append(msa.queue, &ServerSentMsg{Type: MsgServerBroadcastResult, Payload: payload})
+		}
+
+		return &ServerSentMsg{Type: msgType, Payload: payload}, nil
+	}
+}
+
+func (msa *MQTTServerAdapter) connect() error {
+	var body []byte
+	body = mqttAppendString(body, "MQTT")
+	if MQTTConfig.Version == 5 {
+		body = append(body, 5)
+	} else {
+		body = append(body, 4)
+	}
+
+	// Clean session
+	flags := byte(0x02)
+	if MQTTConfig.Username != "" {
+		flags |= 0x80
+	}
+	if MQTTConfig.Password != "" {
+		flags |= 0x40
+	}
+	body = append(body, flags)
+	body = mqttAppendUint16(body, uint16(MQTTConfig.KeepAlive/time.Second))
+	body = msa.appendProperties(body)
+
+	body = mqttAppendString(body, msa.clientID)
+	if MQTTConfig.Username != "" {
+		body = mqttAppendString(body, MQTTConfig.Username)
+	}
+	if MQTTConfig.Password != "" {
+		body = mqttAppendString(body, MQTTConfig.Password)
+	}
+
+	if err := msa.send(mqttConnect, body); err != nil {
+		return err
+	}
+
+	packet, err := msa.receivePacket()
+	if err != nil {
+		return err
+	}
+
+	if packet.Type != mqttConnack || len(packet.Body) < 2 {
+		return fmt.Errorf("expected CONNACK packet, got 0x%02x", packet.Type)
+	}
+
+	if code := packet.Body[1]; code != 0 {
+		return fmt.Errorf("connection refused with reason code 0x%02x", code)
+	}
+
+	return nil
+}
+
+func (msa *MQTTServerAdapter) publish(topic, action string, payload *Payload) error {
+	data, err := json.Marshal(&mqttMsg{Action: action, Client: msa.clientID, Payload: payloadTojsonPayload(payload)})
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	body = mqttAppendString(body, topic)
+	if MQTTConfig.QoS > 0 {
+		body = mqttAppendUint16(body, msa.nextPacketID())
+	}
+	body = msa.appendProperties(body)
+	body = append(body, data...)
+
+	return msa.send(mqttPublish|byte(MQTTConfig.QoS<<1), body)
+}
+
+// acknowledge continues the QoS 2 flow for PUBREC and PUBREL packets
+func (msa *MQTTServerAdapter) acknowledge(packet *mqttPacket) error {
+	if len(packet.Body) < 2 {
+		return errors.New("malformed MQTT packet")
+	}
+
+	if packet.Type == mqttPubrec {
+		return msa.send(mqttPubrel|0x02, packet.Body[:2])
+	}
+
+	return msa.send(mqttPubcomp, packet.Body[:2])
+}
+
+// handlePublish acknowledges the incoming PUBLISH packet and returns its payload
+func (msa *MQTTServerAdapter) handlePublish(packet *mqttPacket) ([]byte, error) {
+	body := packet.Body
+
+	topicLen, body, err := mqttReadUint16(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < int(topicLen) {
+		return nil, errors.New("malformed PUBLISH packet")
+	}
+	body = body[topicLen:]
+
+	qos := (packet.Flags >> 1) & 0x03
+	if qos > 0 {
+		if len(body) < 2 {
+			return nil, errors.New("malformed PUBLISH packet")
+		}
+
+		ack := byte(mqttPuback)
+		if qos == 2 {
+			ack = mqttPubrec
+		}
+		if err := msa.send(ack, body[:2]); err != nil {
+			return nil, err
+		}
+
+		body = body[2:]
+	}
+
+	if MQTTConfig.Version == 5 {
+		body, err = mqttSkipProperties(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+func (msa *MQTTServerAdapter) echoTopic() string {
+	return "websocket-bench/echo/" + msa.clientID
+}
+
+func (msa *MQTTServerAdapter) nextPacketID() uint16 {
+	msa.mu.Lock()
+	defer msa.mu.Unlock()
+
+	msa.lastPacketID++
+	if msa.lastPacketID == 0 {
+		msa.lastPacketID = 1
+	}
+
+	return msa.lastPacketID
+}
+
+// appendProperties appends an empty properties section for MQTT 5
+func (msa *MQTTServerAdapter) appendProperties(body []byte) []byte {
+	if MQTTConfig.Version == 5 {
+		return append(body, 0)
+	}
+	return body
+}
+
+func (msa *MQTTServerAdapter) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-msa.done:
+			return
+		case <-ticker.C:
+			if err := msa.send(mqttPingreq, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (msa *MQTTServerAdapter) send(header byte, body []byte) error {
+	buf := make([]byte, 1+binary.MaxVarintLen32+len(body))
+	buf[0] = header
+	n := binary.PutUvarint(buf[1:], uint64(len(body)))
+	buf = append(buf[:1+n], body...)
+
+	return websocket.Message.Send(msa.conn, buf)
+}
+
+func (msa *MQTTServerAdapter) receivePacket() (*mqttPacket, error) {
+	packet, err := readMQTTPacket(msa.reader)
+	if err != nil {
+		select {
+		case <-msa.done:
+		default:
+			close(msa.done)
+		}
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+func readMQTTPacket(r *bufio.Reader) (*mqttPacket, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return &mqttPacket{Type: header & mqttPacketTypes, Flags: header & 0x0f, Body: body}, nil
+}
+
+func mqttAppendUint16(buf []byte, value uint16) []byte {
+	return append(buf, byte(value>>8), byte(value))
+}
+
+func mqttAppendString(buf []byte, s string) []byte {
+	buf = mqttAppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func mqttReadUint16(body []byte) (uint16, []byte, error) {
+	if len(body) < 2 {
+		return 0, nil, errors.New("malformed MQTT packet")
+	}
+	return binary.BigEndian.Uint16(body), body[2:], nil
+}
+
+func mqttSkipProperties(body []byte) ([]byte, error) {
+	// MQTT variable byte integers are encoded the same way as unsigned varints
+	length, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < length {
+		return nil, errors.New("malformed MQTT properties")
+	}
+
+	return body[n+int(length):], nil
+}
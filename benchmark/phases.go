@@ -0,0 +1,50 @@
+package benchmark
+
+import (
+	"sync"
+	"time"
+)
+
+// Phases splits the connection time into the handshake phases: dns (only if
+// the client resolves its own URL, see Identity), tcp_connect (or h2_stream
+// over a shared HTTP/2 connection), upgrade and the server type specific ones
+// (e.g. welcome and subscription).
+//
+// Every phase is reported as the "phase_<name>" step metric with the time
+// passed since the end of the previous phase, with sub-millisecond precision.
+// The TLS handshake between tcp_connect and upgrade is the tls_handshake
+// metric reported by tlsHandshake.
+type Phases struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newPhases() *Phases {
+	return &Phases{last: time.Now()}
+}
+
+// Mark ends the phase; it's a no-op for the nil phases (e.g. on reconnect)
+func (p *Phases) Mark(name string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	d := now.Sub(p.last)
+	p.last = now
+	p.mu.Unlock()
+
+	stepMetrics.AddPreciseLatency("phase_"+name, d)
+}
+
+// Skip ends the phase reported by another metric (e.g. tls_handshake)
+func (p *Phases) Skip() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.last = time.Now()
+	p.mu.Unlock()
+}
@@ -1,96 +1,392 @@
 package benchmark
 
 import (
+	"encoding/json"
 	"fmt"
-	"golang.org/x/net/websocket"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var PhoenixConfig struct {
+	Topic      string
+	JoinParams string
+	Serializer string
+	Heartbeat  time.Duration
+}
+
+const (
+	PhoenixSerializerV1 = "v1"
+	PhoenixSerializerV2 = "v2"
 )
 
+func init() {
+	flags := pflag.NewFlagSet("phoenix", pflag.ContinueOnError)
+	flags.StringVar(&PhoenixConfig.Topic, "phoenix-topic", "room:lobby", "Phoenix channel topic to join")
+	flags.StringVar(&PhoenixConfig.JoinParams, "phoenix-join-params", "", "Phoenix channel join params (JSON)")
+	flags.StringVar(&PhoenixConfig.Serializer, "phoenix-serializer", PhoenixSerializerV2, "Phoenix serializer version (v1, v2)")
+	flags.DurationVar(&PhoenixConfig.Heartbeat, "phoenix-heartbeat", 30*time.Second, "Phoenix heartbeat interval (0 to disable)")
+
+	RegisterServerType(&ServerType{
+		Name:      "phoenix",
+		New:       newPhoenixServerAdapter,
+		Flags:     flags,
+		Handshake: phoenixHandshake,
+	})
+}
+
+// phoenixHandshake adds the serializer version to the socket URL unless it's specified
+func phoenixHandshake(config *websocket.Config) {
+	query := config.Location.Query()
+	if query.Get("vsn") != "" {
+		return
+	}
+
+	if PhoenixConfig.Serializer == PhoenixSerializerV1 {
+		query.Set("vsn", "1.0.0")
+	} else {
+		query.Set("vsn", "2.0.0")
+	}
+	config.Location.RawQuery = query.Encode()
+}
+
+// PhoenixServerAdapter joins a Phoenix channel topic, pushes "echo" and
+// "broadcast" events and matches replies to pushes by their refs.
+// Heartbeat latencies are reported as the "heartbeat" step metric.
 type PhoenixServerAdapter struct {
-	conn *websocket.Conn
+	conn    *websocket.Conn
+	joinRef string
+
+	mu      sync.Mutex
+	lastRef int
+	pending map[string]*psaRequest
+
+	done chan struct{}
 }
 
 type psaMsg struct {
-	Topic   string                 `json:"topic"`
-	Event   string                 `json:"event"`
-	Payload map[string]interface{} `json:"payload"`
-	Ref     string                 `json:"ref"`
+	JoinRef string
+	Ref     string
+	Topic   string
+	Event   string
+	Payload json.RawMessage
+}
+
+type psaV1Msg struct {
+	JoinRef *string         `json:"join_ref"`
+	Ref     *string         `json:"ref"`
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type psaReply struct {
+	Status   string          `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+type psaBody struct {
+	Type string       `json:"type"`
+	Body *jsonPayload `json:"body"`
+}
+
+// psaRequest is a push waiting for the server reply
+type psaRequest struct {
+	event   string
+	payload *Payload
+	sentAt  time.Time
+}
+
+func newPhoenixServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	psa := &PhoenixServerAdapter{conn: conn}
+	if err := psa.Startup(); err != nil {
+		return nil, err
+	}
+	return psa, nil
 }
 
 func (psa *PhoenixServerAdapter) Startup() error {
-	err := websocket.JSON.Send(psa.conn, &psaMsg{
-		Topic: "room:lobby",
-		Event: "phx_join",
+	psa.pending = make(map[string]*psaRequest)
+	psa.done = make(chan struct{})
+
+	joinParams := json.RawMessage("{}")
+	if PhoenixConfig.JoinParams != "" {
+		joinParams = json.RawMessage(PhoenixConfig.JoinParams)
+	}
+
+	psa.joinRef = psa.nextRef()
+
+	err := psa.send(&psaMsg{
+		JoinRef: psa.joinRef,
+		Ref:     psa.joinRef,
+		Topic:   PhoenixConfig.Topic,
+		Event:   "phx_join",
+		Payload: joinParams,
 	})
 	if err != nil {
 		return err
 	}
 
-	var confirmSubMsg psaMsg
-	err = websocket.JSON.Receive(psa.conn, &confirmSubMsg)
-	if err != nil {
-		return err
+	for {
+		msg, err := psa.receive()
+		if err != nil {
+			return err
+		}
+
+		if msg.Topic != PhoenixConfig.Topic || msg.Ref != psa.joinRef {
+			continue
+		}
+
+		if msg.Event != "phx_reply" {
+			return fmt.Errorf("expected phx_reply msg, got %v", msg)
+		}
+
+		var reply psaReply
+		if err := json.Unmarshal(msg.Payload, &reply); err != nil {
+			return err
+		}
+
+		if reply.Status != "ok" {
+			return fmt.Errorf("failed to join %s: %s", PhoenixConfig.Topic, msg.Payload)
+		}
+
+		break
 	}
 
-	if confirmSubMsg.Topic != "room:lobby" || confirmSubMsg.Event != `phx_reply` {
-		return fmt.Errorf("expected phx_reply msg, got %v", confirmSubMsg)
+	if PhoenixConfig.Heartbeat > 0 {
+		go psa.heartbeat(PhoenixConfig.Heartbeat)
 	}
 
 	return nil
 }
 
 func (psa *PhoenixServerAdapter) SendEcho(payload *Payload) error {
-	return websocket.JSON.Send(psa.conn, &psaMsg{
-		Topic:   "room:lobby",
-		Event:   "echo",
-		Payload: map[string]interface{}{"body": payload},
-	})
+	return psa.push("echo", payload)
 }
 
 func (psa *PhoenixServerAdapter) SendBroadcast(payload *Payload) error {
-	return websocket.JSON.Send(psa.conn, &psaMsg{
-		Topic:   "room:lobby",
-		Event:   "broadcast",
-		Payload: map[string]interface{}{"body": payload},
-	})
+	return psa.push("broadcast", payload)
 }
 
-func (psa *PhoenixServerAdapter) Receive() (*serverSentMsg, error) {
-	var msg psaMsg
-	err := websocket.JSON.Receive(psa.conn, &msg)
-	if err != nil {
-		return nil, err
+func (psa *PhoenixServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		msg, err := psa.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Event == "phx_reply" {
+			res, err := psa.handleReply(msg)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				continue
+			}
+			return res, nil
+		}
+
+		if msg.Topic != PhoenixConfig.Topic {
+			continue
+		}
+
+		switch msg.Event {
+		case "phx_error", "phx_close":
+			return nil, fmt.Errorf("channel %s closed: %s", msg.Topic, msg.Event)
+		}
+
+		var body psaBody
+		if err := json.Unmarshal(msg.Payload, &body); err != nil {
+			return nil, err
+		}
+
+		// Skip events without the benchmark payload (e.g. presence)
+		if body.Body == nil {
+			continue
+		}
+
+		if body.Type == "" {
+			body.Type = msg.Event
+		}
+
+		msgType, err := ParseMessageType(body.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := jsonPayloadToPayload(body.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ServerSentMsg{Type: msgType, Payload: payload}, nil
 	}
-	if msg.Topic != "room:lobby" {
-		return nil, fmt.Errorf("unexpected msg, got %v", msg)
+}
+
+// handleReply matches the reply with the pending push; it returns nil
+// for the replies which are not reported to the benchmark (e.g. heartbeats)
+func (psa *PhoenixServerAdapter) handleReply(msg *psaMsg) (*ServerSentMsg, error) {
+	psa.mu.Lock()
+	req, ok := psa.pending[msg.Ref]
+	delete(psa.pending, msg.Ref)
+	psa.mu.Unlock()
+
+	if !ok {
+		return nil, nil
 	}
 
-	var psaPayload map[string]interface{}
+	var reply psaReply
+	if err := json.Unmarshal(msg.Payload, &reply); err != nil {
+		return nil, err
+	}
 
-	if response, ok := msg.Payload["response"].(map[string]interface{}); ok {
-		psaPayload = response
-	} else {
-		psaPayload = msg.Payload
+	if reply.Status != "ok" {
+		return nil, fmt.Errorf("%s push failed: %s", req.event, msg.Payload)
 	}
 
-	body := psaPayload["body"].(map[string]interface{})
+	switch req.event {
+	case "heartbeat":
+		stepMetrics.AddLatency("heartbeat", time.Since(req.sentAt))
+		return nil, nil
+	case "echo":
+		return &ServerSentMsg{Type: MsgServerEcho, Payload: req.payload}, nil
+	default:
+		return &ServerSentMsg{Type: MsgServerBroadcastResult, Payload: req.payload}, nil
+	}
+}
 
-	payload := &Payload{}
-	unixNanosecond, err := strconv.ParseInt(body["sendTime"].(string), 10, 64)
+func (psa *PhoenixServerAdapter) push(event string, payload *Payload) error {
+	body, err := json.Marshal(map[string]interface{}{"body": payloadTojsonPayload(payload)})
 	if err != nil {
+		return err
+	}
+
+	ref := psa.nextRef()
+
+	psa.mu.Lock()
+	psa.pending[ref] = &psaRequest{event: event, payload: payload, sentAt: time.Now()}
+	psa.mu.Unlock()
+
+	return psa.send(&psaMsg{
+		JoinRef: psa.joinRef,
+		Ref:     ref,
+		Topic:   PhoenixConfig.Topic,
+		Event:   event,
+		Payload: body,
+	})
+}
+
+func (psa *PhoenixServerAdapter) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-psa.done:
+			return
+		case <-ticker.C:
+			ref := psa.nextRef()
+
+			psa.mu.Lock()
+			psa.pending[ref] = &psaRequest{event: "heartbeat", sentAt: time.Now()}
+			psa.mu.Unlock()
+
+			err := psa.send(&psaMsg{Ref: ref, Topic: "phoenix", Event: "heartbeat", Payload: json.RawMessage("{}")})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (psa *PhoenixServerAdapter) nextRef() string {
+	psa.mu.Lock()
+	defer psa.mu.Unlock()
+
+	psa.lastRef++
+	return strconv.Itoa(psa.lastRef)
+}
+
+func (psa *PhoenixServerAdapter) send(msg *psaMsg) error {
+	if PhoenixConfig.Serializer == PhoenixSerializerV1 {
+		return websocket.JSON.Send(psa.conn, &psaV1Msg{
+			JoinRef: psaNullableRef(msg.JoinRef),
+			Ref:     psaNullableRef(msg.Ref),
+			Topic:   msg.Topic,
+			Event:   msg.Event,
+			Payload: msg.Payload,
+		})
+	}
+
+	return websocket.JSON.Send(psa.conn, []interface{}{
+		psaNullableRef(msg.JoinRef),
+		psaNullableRef(msg.Ref),
+		msg.Topic,
+		msg.Event,
+		msg.Payload,
+	})
+}
+
+func (psa *PhoenixServerAdapter) receive() (*psaMsg, error) {
+	var data []byte
+	if err := websocket.Message.Receive(psa.conn, &data); err != nil {
+		psa.stopHeartbeat()
 		return nil, err
 	}
-	payload.SendTime = time.Unix(0, unixNanosecond)
 
-	if padding, ok := body["padding"]; ok {
-		payload.Padding = []byte(padding.(string))
+	if PhoenixConfig.Serializer == PhoenixSerializerV1 {
+		var v1 psaV1Msg
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+
+		msg := &psaMsg{Topic: v1.Topic, Event: v1.Event, Payload: v1.Payload}
+		if v1.JoinRef != nil {
+			msg.JoinRef = *v1.JoinRef
+		}
+		if v1.Ref != nil {
+			msg.Ref = *v1.Ref
+		}
+		return msg, nil
 	}
 
-	msgType, err := ParseMessageType(psaPayload["type"].(string))
-	if err != nil {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
 		return nil, err
 	}
 
-	return &serverSentMsg{Type: msgType, Payload: payload}, nil
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unexpected msg, got %s", data)
+	}
+
+	msg := &psaMsg{Payload: fields[4]}
+	for i, dst := range []*string{&msg.JoinRef, &msg.Ref, &msg.Topic, &msg.Event} {
+		var value *string
+		if err := json.Unmarshal(fields[i], &value); err != nil {
+			return nil, err
+		}
+		if value != nil {
+			*dst = *value
+		}
+	}
+
+	return msg, nil
+}
+
+func (psa *PhoenixServerAdapter) stopHeartbeat() {
+	select {
+	case <-psa.done:
+	default:
+		close(psa.done)
+	}
+}
+
+func psaNullableRef(ref string) *string {
+	if ref == "" {
+		return nil
+	}
+	return &ref
 }
@@ -0,0 +1,170 @@
+package benchmark
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ParseProxyURL validates the proxy URL: http://[user:password@]host:port
+// for HTTP CONNECT proxies or socks5://[user:password@]host:port
+func ParseProxyURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy port is required: %s", rawurl)
+	}
+
+	return u, nil
+}
+
+// dialRemote opens a TCP or Unix socket connection to the remote address,
+// directly or through the proxy. The time it takes to establish the proxy
+// tunnel is reported as the "proxy_connect" step metric.
+func dialRemote(laddr *net.TCPAddr, proxyURL *url.URL, raddr net.Addr, host string) (net.Conn, error) {
+	if unixAddr, ok := raddr.(*net.UnixAddr); ok {
+		if proxyURL != nil {
+			return nil, errors.New("Unix sockets can't be reached through proxies")
+		}
+		conn, err := net.DialUnix("unix", nil, unixAddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	tcpAddr := raddr.(*net.TCPAddr)
+
+	if len(LocalPortRanges) == 0 {
+		return dialTCP(laddr, proxyURL, tcpAddr, host)
+	}
+
+	// Bind the planned source ports, skipping the ones in use by other sockets
+	for {
+		src, err := nextLocalAddr(laddr)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dialTCP(src, proxyURL, tcpAddr, host)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			continue
+		}
+		return conn, err
+	}
+}
+
+func dialTCP(laddr *net.TCPAddr, proxyURL *url.URL, raddr *net.TCPAddr, host string) (net.Conn, error) {
+	if proxyURL == nil {
+		conn, err := net.DialTCP("tcp", laddr, raddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	// The proxy resolves the host name
+	target := net.JoinHostPort(host, strconv.Itoa(raddr.Port))
+
+	dialer := &net.Dialer{Timeout: ConnectionTimeout}
+	if laddr != nil {
+		dialer.LocalAddr = laddr
+	}
+
+	start := time.Now()
+
+	var conn net.Conn
+	var err error
+	if proxyURL.Scheme == "socks5" {
+		conn, err = dialSOCKS5(dialer, proxyURL, target)
+	} else {
+		conn, err = dialHTTPConnect(dialer, proxyURL, target)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Host, err)
+	}
+
+	stepMetrics.AddLatency("proxy_connect", time.Since(start))
+
+	return conn, nil
+}
+
+func dialSOCKS5(dialer *net.Dialer, proxyURL *url.URL, target string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		auth = &proxy.Auth{User: proxyURL.User.Username()}
+		auth.Password, _ = proxyURL.User.Password()
+	}
+
+	socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	return socks.Dial("tcp", target)
+}
+
+func dialHTTPConnect(dialer *net.Dialer, proxyURL *url.URL, target string) (net.Conn, error) {
+	conn, err := dialer.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	conn.SetDeadline(time.Now().Add(ConnectionTimeout))
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT failed: %s", res.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// The server speaks after the client only, so nothing must be buffered
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("unexpected data after the CONNECT response")
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,156 @@
+package benchmark
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+// ServerType describes a server adapter selectable via --server-type.
+//
+// Adapters register themselves with RegisterServerType (usually from init),
+// so a custom binary can import this package and add its own adapters.
+type ServerType struct {
+	// Name is the --server-type value
+	Name string
+	// New creates the adapter for the established client connection
+	New func(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error)
+	// Flags are the adapter specific command line flags (optional). Server
+	// types may share a flag set; the values are forwarded to the workers.
+	Flags *pflag.FlagSet
+	// Configure validates the flag values before the clients are created (optional)
+	Configure func() error
+	// Handshake adjusts the WebSocket handshake config, e.g. sets the default
+	// sub-protocols (optional)
+	Handshake func(config *websocket.Config)
+	// SessionRecovery is set if the adapter restores its session over a new
+	// connection (see sessionRecoverer), which --drop-percentage requires
+	SessionRecovery bool
+}
+
+// ClientInfo describes the client the adapter is created for
+type ClientInfo struct {
+	ID int
+	// InitTime is the time the client started to connect
+	InitTime time.Time
+	// Identity is the client data from the identities file (nil if not used)
+	Identity Identity
+	// Phases reports the server type specific handshake phases (e.g. welcome)
+	Phases *Phases
+}
+
+var serverTypes = struct {
+	sync.RWMutex
+	m map[string]*ServerType
+}{m: make(map[string]*ServerType)}
+
+// RegisterServerType adds the server type to the registry; it panics if the
+// name is empty or already registered
+func RegisterServerType(st *ServerType) {
+	if st.Name == "" || st.New == nil {
+		panic("server type must have a name and a constructor")
+	}
+
+	serverTypes.Lock()
+	defer serverTypes.Unlock()
+
+	if _, ok := serverTypes.m[st.Name]; ok {
+		panic(fmt.Sprintf("server type %s is already registered", st.Name))
+	}
+
+	serverTypes.m[st.Name] = st
+}
+
+// LookupServerType returns the registered server type by name
+func LookupServerType(name string) (*ServerType, error) {
+	serverTypes.RLock()
+	defer serverTypes.RUnlock()
+
+	st, ok := serverTypes.m[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown server type: %v", name)
+	}
+
+	return st, nil
+}
+
+// ServerTypes returns the registered server types sorted by name
+func ServerTypes() []*ServerType {
+	serverTypes.RLock()
+	defer serverTypes.RUnlock()
+
+	types := make([]*ServerType, 0, len(serverTypes.m))
+	for _, st := range serverTypes.m {
+		types = append(types, st)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+
+	return types
+}
+
+// ServerTypeNames returns the names of the registered server types sorted by name
+func ServerTypeNames() []string {
+	var names []string
+	for _, st := range ServerTypes() {
+		names = append(names, st.Name)
+	}
+	return names
+}
+
+// ServerTypeFlags returns all the adapter specific flags
+func ServerTypeFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("server types", pflag.ContinueOnError)
+	for _, st := range ServerTypes() {
+		if st.Flags != nil {
+			flags.AddFlagSet(st.Flags)
+		}
+	}
+	return flags
+}
+
+// ServerTypeOptions returns the values of the server type flags changed
+// from the defaults
+func ServerTypeOptions(name string) (map[string]string, error) {
+	st, err := LookupServerType(name)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string)
+	if st.Flags != nil {
+		st.Flags.VisitAll(func(f *pflag.Flag) {
+			if f.Changed {
+				options[f.Name] = f.Value.String()
+			}
+		})
+	}
+
+	return options, nil
+}
+
+// ConfigureServerType sets the server type flags and validates them
+func ConfigureServerType(name string, options map[string]string) error {
+	st, err := LookupServerType(name)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range options {
+		if st.Flags == nil {
+			return fmt.Errorf("unknown %s flag: %s", name, k)
+		}
+		if err := st.Flags.Set(k, v); err != nil {
+			return err
+		}
+	}
+
+	if st.Configure != nil {
+		return st.Configure()
+	}
+
+	return nil
+}
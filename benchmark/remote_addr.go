@@ -0,0 +1,111 @@
+package benchmark
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/websocket"
+)
+
+var RemoteAddr struct {
+	// Addrs are the TCP or Unix socket addresses the clients are spread across
+	Addrs  []net.Addr
+	Host   string
+	Config *websocket.Config
+	Secure bool
+
+	next uint32
+}
+
+// unixAddrPrefix marks the Unix socket paths in the remote addresses list
+const unixAddrPrefix = "unix:"
+
+// ResolveRemoteAddr resolves the host:port address to connect to and returns it with the host name
+func ResolveRemoteAddr(hostport string) (*net.TCPAddr, string, error) {
+	addrs, host, err := resolveRemoteAddrs(hostport)
+	if err != nil {
+		return nil, "", err
+	}
+
+	addr := addrs[0]
+	if host == "localhost" {
+		addr.IP = nil
+	}
+
+	return addr, host, nil
+}
+
+// ResolveAllRemoteAddrs resolves the host:port address to all the A/AAAA records of the host
+func ResolveAllRemoteAddrs(hostport string) ([]net.Addr, error) {
+	addrs, _, err := resolveRemoteAddrs(hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]net.Addr, len(addrs))
+	for i, addr := range addrs {
+		result[i] = addr
+	}
+
+	return result, nil
+}
+
+// ParseRemoteAddr parses the host:port or unix:/path/to/socket address
+func ParseRemoteAddr(s string) (net.Addr, error) {
+	if strings.HasPrefix(s, unixAddrPrefix) {
+		return net.ResolveUnixAddr("unix", strings.TrimPrefix(s, unixAddrPrefix))
+	}
+
+	addr, _, err := ResolveRemoteAddr(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return addr, nil
+}
+
+func resolveRemoteAddrs(hostport string) ([]*net.TCPAddr, string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, "", err
+	}
+
+	destIPs, err := net.LookupHost(host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nport, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	addrs := make([]*net.TCPAddr, len(destIPs))
+	for i, ip := range destIPs {
+		addrs[i] = &net.TCPAddr{IP: net.ParseIP(ip), Port: int(nport)}
+	}
+
+	return addrs, host, nil
+}
+
+// nextRemoteAddr returns the remote address for the next client in turn
+func nextRemoteAddr() net.Addr {
+	n := atomic.AddUint32(&RemoteAddr.next, 1) - 1
+	return RemoteAddr.Addrs[int(n%uint32(len(RemoteAddr.Addrs)))]
+}
+
+// remoteAddrLabel returns the name of the address in the per-address metrics,
+// or an empty string if the clients connect to a single address
+func remoteAddrLabel(addr net.Addr) string {
+	if len(RemoteAddr.Addrs) < 2 {
+		return ""
+	}
+
+	if _, ok := addr.(*net.UnixAddr); ok {
+		return unixAddrPrefix + addr.String()
+	}
+
+	return addr.String()
+}
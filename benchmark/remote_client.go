@@ -23,6 +23,7 @@ type remoteClient struct {
 	rttResultChan        chan time.Duration
 	errChan              chan error
 	rxBroadcastCountChan chan int
+	reconnectChan        chan *WorkerReconnectMsg
 }
 
 func NewRemoteClientPool(addr string) (*RemoteClientPool, error) {
@@ -49,12 +50,23 @@ func (rcp *RemoteClientPool) New(
 	errChan chan error,
 	padding []byte,
 ) (Client, error) {
+	serverOptions, err := ServerTypeOptions(serverType)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := nextIdentity()
+	if err != nil {
+		return nil, err
+	}
+
 	client := &remoteClient{
 		clientPool:           rcp,
 		id:                   id,
 		rttResultChan:        rttResultChan,
 		errChan:              errChan,
 		rxBroadcastCountChan: make(chan int),
+		reconnectChan:        make(chan *WorkerReconnectMsg),
 	}
 	rcp.clients[id] = client
 
@@ -63,13 +75,19 @@ func (rcp *RemoteClientPool) New(
 		Type:     "connect",
 	}
 	msg.Connect = &WorkerConnectMsg{
-		Dest:       dest,
-		Origin:     origin,
-		ServerType: serverType,
-		Padding:    padding,
+		Dest:          dest,
+		Origin:        origin,
+		ServerType:    serverType,
+		ServerOptions: serverOptions,
+		Padding:       padding,
+		Header:        HandshakeConfig.Header,
+		Cookies:       HandshakeConfig.Cookies,
+		Query:         HandshakeConfig.Query,
+		JWT:           JWTConfig,
+		Identity:      identity,
 	}
 
-	err := rcp.encoder.Encode(msg)
+	err = rcp.encoder.Encode(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +114,15 @@ func (rcp *RemoteClientPool) rx() {
 		case "rttResult":
 			rcp.clients[msg.ClientID].rttResultChan <- msg.RTTResult.Duration
 		case "error":
-			rcp.clients[msg.ClientID].errChan <- errors.New(msg.Error.Msg)
+			class := msg.Error.Class
+			if class == "" {
+				class = ErrorClassOther
+			}
+			rcp.clients[msg.ClientID].errChan <- classify(class, errors.New(msg.Error.Msg))
 		case "rxBroadcastCount":
 			rcp.clients[msg.ClientID].rxBroadcastCountChan <- msg.RxBroadcastCount.Count
+		case "reconnect":
+			rcp.clients[msg.ClientID].reconnectChan <- msg.Reconnect
 		default:
 			log.Println("unknown message:", msg.Type)
 		}
@@ -143,3 +167,22 @@ func (c *remoteClient) ResetRxBroadcastCount() (int, error) {
 
 	return count, nil
 }
+
+func (c *remoteClient) Reconnect() (time.Duration, error) {
+	msg := WorkerMsg{
+		ClientID: c.id,
+		Type:     "reconnect",
+	}
+
+	err := c.clientPool.encoder.Encode(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	result := <-c.reconnectChan
+	if result.Error != "" {
+		return 0, errors.New(result.Error)
+	}
+
+	return result.Duration, nil
+}
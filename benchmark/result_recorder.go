@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"time"
 )
 
@@ -16,6 +17,8 @@ type ResultRecorder interface {
 		rttMedian time.Duration,
 		rttMax time.Duration,
 	) error
+	RecordMetrics(metrics *StepMetrics) error
+	RecordErrors(errs *StepErrors) error
 	Message(str string)
 	Flush() error
 }
@@ -49,6 +52,72 @@ func (jrr *JSONResultRecorder) Record(
 	return nil
 }
 
+// RecordMetrics adds the step metrics to the last recorded step
+func (jrr *JSONResultRecorder) RecordMetrics(metrics *StepMetrics) error {
+	if len(jrr.records) == 0 {
+		return nil
+	}
+
+	values := make(map[string]interface{})
+
+	for _, l := range metrics.Latencies {
+		if l.Precise {
+			values[l.Name] = map[string]interface{}{
+				"count":  l.Count,
+				"per":    fractionalMS(l.Percentile),
+				"min":    fractionalMS(l.Min),
+				"median": fractionalMS(l.Median),
+				"max":    fractionalMS(l.Max),
+			}
+			continue
+		}
+
+		values[l.Name] = map[string]interface{}{
+			"count":  l.Count,
+			"per":    roundToMS(l.Percentile),
+			"min":    roundToMS(l.Min),
+			"median": roundToMS(l.Median),
+			"max":    roundToMS(l.Max),
+		}
+	}
+
+	for _, c := range metrics.Counters {
+		values[c.Name] = c.Value
+	}
+
+	jrr.records[len(jrr.records)-1]["metrics"] = values
+
+	return nil
+}
+
+// RecordErrors adds the error counts by class and the error rate to the last recorded step
+func (jrr *JSONResultRecorder) RecordErrors(errs *StepErrors) error {
+	if len(jrr.records) == 0 {
+		return nil
+	}
+
+	jrr.records[len(jrr.records)-1]["errors"] = map[string]interface{}{
+		"connect":  jsonErrorStats(errs.Connect),
+		"commands": jsonErrorStats(errs.Command),
+	}
+
+	return nil
+}
+
+func jsonErrorStats(stats ErrorStats) map[string]interface{} {
+	counts := make(map[string]int)
+	for _, c := range stats.Counts {
+		counts[c.Class] = c.Count
+	}
+
+	return map[string]interface{}{
+		"total":    stats.Total,
+		"attempts": stats.Attempts,
+		"rate":     math.Round(stats.Rate*100) / 100,
+		"counts":   counts,
+	}
+}
+
 func (jrr *JSONResultRecorder) Message(str string) {
 	jrr.messages = append(jrr.messages, str)
 }
@@ -102,6 +171,81 @@ func (trr *TextResultRecorder) Record(
 	return err
 }
 
+func (trr *TextResultRecorder) RecordMetrics(metrics *StepMetrics) error {
+	for _, l := range metrics.Latencies {
+		if l.Precise {
+			_, err := fmt.Fprintf(trr.w,
+				"    %s: count: %5d    %dper: %7.3fms    min: %7.3fms    median: %7.3fms    max: %7.3fms\n",
+				l.Name,
+				l.Count,
+				metrics.LimitPercentile,
+				fractionalMS(l.Percentile),
+				fractionalMS(l.Min),
+				fractionalMS(l.Median),
+				fractionalMS(l.Max),
+			)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := fmt.Fprintf(trr.w,
+			"    %s: count: %5d    %dper: %3dms    min: %3dms    median: %3dms    max: %3dms\n",
+			l.Name,
+			l.Count,
+			metrics.LimitPercentile,
+			roundToMS(l.Percentile),
+			roundToMS(l.Min),
+			roundToMS(l.Median),
+			roundToMS(l.Max),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range metrics.Counters {
+		if _, err := fmt.Fprintf(trr.w, "    %s: %d\n", c.Name, c.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (trr *TextResultRecorder) RecordErrors(errs *StepErrors) error {
+	if err := trr.recordErrorStats("connect errors", "connections", errs.Connect); err != nil {
+		return err
+	}
+
+	return trr.recordErrorStats("command errors", "samples", errs.Command)
+}
+
+func (trr *TextResultRecorder) recordErrorStats(title, unit string, stats ErrorStats) error {
+	if stats.Total == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(trr.w, "    %s: %d of %d %s (%.2f%%)\n", title, stats.Total, stats.Attempts, unit, stats.Rate)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range stats.Counts {
+		if _, err := fmt.Fprintf(trr.w, "      %s: %d\n", c.Class, c.Count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fractionalMS returns the duration in milliseconds rounded to microseconds
+func fractionalMS(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
 func roundToMS(d time.Duration) int64 {
 	return int64((d + (500 * time.Microsecond)) / time.Millisecond)
 }
@@ -0,0 +1,251 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var SignalRConfig struct {
+	EchoMethod      string
+	BroadcastMethod string
+	BroadcastTarget string
+	Ping            time.Duration
+}
+
+func init() {
+	flags := pflag.NewFlagSet("signalr", pflag.ContinueOnError)
+	flags.StringVar(&SignalRConfig.EchoMethod, "signalr-echo-method", "Echo", "SignalR hub method to invoke for echo")
+	flags.StringVar(&SignalRConfig.BroadcastMethod, "signalr-broadcast-method", "Broadcast", "SignalR hub method to invoke for broadcast")
+	flags.StringVar(&SignalRConfig.BroadcastTarget, "signalr-broadcast-target", "broadcast", "SignalR client method the hub sends broadcasts to")
+	flags.DurationVar(&SignalRConfig.Ping, "signalr-ping", 15*time.Second, "SignalR keep alive ping interval (0 to disable)")
+
+	RegisterServerType(&ServerType{
+		Name:  "signalr",
+		New:   newSignalRServerAdapter,
+		Flags: flags,
+	})
+}
+
+// SignalR message types
+const (
+	signalRInvocation = 1
+	signalRCompletion = 3
+	signalRPing       = 6
+	signalRClose      = 7
+)
+
+// signalRRecordSeparator terminates every JSON hub protocol message
+const signalRRecordSeparator = 0x1e
+
+// SignalRServerAdapter speaks the ASP.NET Core SignalR JSON hub protocol over
+// WebSocket without negotiation (the URL must point to the hub endpoint).
+//
+// Echo and broadcast invoke the hub methods with the payload as the only
+// argument, and their completions are used to measure the RTT. The hub is
+// expected to send the broadcast payload to all the clients by invoking the
+// broadcast target client method.
+type SignalRServerAdapter struct {
+	conn *websocket.Conn
+
+	mu      sync.Mutex
+	lastID  int
+	pending map[string]*signalRRequest
+
+	records [][]byte
+	done    chan struct{}
+}
+
+type signalRMsg struct {
+	Type         int               `json:"type"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type signalRRequest struct {
+	msgType byte
+	payload *Payload
+}
+
+func newSignalRServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	sra := &SignalRServerAdapter{conn: conn}
+	if err := sra.Startup(); err != nil {
+		return nil, err
+	}
+	return sra, nil
+}
+
+func (sra *SignalRServerAdapter) Startup() error {
+	sra.pending = make(map[string]*signalRRequest)
+	sra.done = make(chan struct{})
+
+	if err := sra.send(map[string]interface{}{"protocol": "json", "version": 1}); err != nil {
+		return err
+	}
+
+	record, err := sra.receiveRecord()
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(record, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return fmt.Errorf("handshake failed: %s", res.Error)
+	}
+
+	if SignalRConfig.Ping > 0 {
+		go sra.ping(SignalRConfig.Ping)
+	}
+
+	return nil
+}
+
+func (sra *SignalRServerAdapter) SendEcho(payload *Payload) error {
+	return sra.invoke(SignalRConfig.EchoMethod, MsgServerEcho, payload)
+}
+
+func (sra *SignalRServerAdapter) SendBroadcast(payload *Payload) error {
+	return sra.invoke(SignalRConfig.BroadcastMethod, MsgServerBroadcastResult, payload)
+}
+
+func (sra *SignalRServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		record, err := sra.receiveRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		var msg signalRMsg
+		if err := json.Unmarshal(record, &msg); err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case signalRCompletion:
+			sra.mu.Lock()
+			req, ok := sra.pending[msg.InvocationID]
+			delete(sra.pending, msg.InvocationID)
+			sra.mu.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			if msg.Error != "" {
+				return nil, fmt.Errorf("invocation failed: %s", msg.Error)
+			}
+
+			return &ServerSentMsg{Type: req.msgType, Payload: req.payload}, nil
+		case signalRInvocation:
+			if msg.Target != SignalRConfig.BroadcastTarget || len(msg.Arguments) == 0 {
+				continue
+			}
+
+			var jp jsonPayload
+			if err := json.Unmarshal(msg.Arguments[0], &jp); err != nil {
+				return nil, err
+			}
+
+			payload, err := jsonPayloadToPayload(&jp)
+			if err != nil {
+				return nil, err
+			}
+
+			return &ServerSentMsg{Type: MsgServerBroadcast, Payload: payload}, nil
+		case signalRClose:
+			if msg.Error != "" {
+				return nil, fmt.Errorf("connection closed by server: %s", msg.Error)
+			}
+			return nil, errors.New("connection closed by server")
+		}
+	}
+}
+
+func (sra *SignalRServerAdapter) invoke(method string, msgType byte, payload *Payload) error {
+	arg, err := json.Marshal(payloadTojsonPayload(payload))
+	if err != nil {
+		return err
+	}
+
+	sra.mu.Lock()
+	sra.lastID++
+	id := strconv.Itoa(sra.lastID)
+	sra.pending[id] = &signalRRequest{msgType: msgType, payload: payload}
+	sra.mu.Unlock()
+
+	return sra.send(&signalRMsg{
+		Type:         signalRInvocation,
+		InvocationID: id,
+		Target:       method,
+		Arguments:    []json.RawMessage{arg},
+	})
+}
+
+func (sra *SignalRServerAdapter) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sra.done:
+			return
+		case <-ticker.C:
+			if err := sra.send(&signalRMsg{Type: signalRPing}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (sra *SignalRServerAdapter) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return websocket.Message.Send(sra.conn, string(append(data, signalRRecordSeparator)))
+}
+
+// receiveRecord returns the next record; a WebSocket message may contain several records
+func (sra *SignalRServerAdapter) receiveRecord() ([]byte, error) {
+	for len(sra.records) == 0 {
+		var data []byte
+		if err := websocket.Message.Receive(sra.conn, &data); err != nil {
+			sra.stopPing()
+			return nil, err
+		}
+
+		for _, record := range bytes.Split(data, []byte{signalRRecordSeparator}) {
+			if len(record) > 0 {
+				sra.records = append(sra.records, record)
+			}
+		}
+	}
+
+	record := sra.records[0]
+	sra.records = sra.records[1:]
+
+	return record, nil
+}
+
+func (sra *SignalRServerAdapter) stopPing() {
+	select {
+	case <-sra.done:
+	default:
+		close(sra.done)
+	}
+}
@@ -0,0 +1,248 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var SocketIOConfig struct {
+	Namespace string
+	Auth      string
+}
+
+func init() {
+	flags := pflag.NewFlagSet("socketio", pflag.ContinueOnError)
+	flags.StringVar(&SocketIOConfig.Namespace, "socketio-namespace", "/", "Socket.IO namespace to connect to")
+	flags.StringVar(&SocketIOConfig.Auth, "socketio-auth", "", "Socket.IO namespace connection auth payload (JSON)")
+
+	RegisterServerType(&ServerType{
+		Name:  "socketio",
+		New:   newSocketIOServerAdapter,
+		Flags: flags,
+	})
+}
+
+// Engine.IO v4 packet types
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+)
+
+// Socket.IO v5 packet types
+const (
+	sioConnect      = '0'
+	sioDisconnect   = '1'
+	sioEvent        = '2'
+	sioConnectError = '4'
+)
+
+// SocketIOServerAdapter speaks Socket.IO over the Engine.IO v4 WebSocket transport
+// (the URL is expected to look like ws://host/socket.io/?EIO=4&transport=websocket).
+// Echo and broadcast are emitted as "echo" and "broadcast" events, and the server
+// is expected to emit "echo", "broadcast" and "broadcastResult" events back.
+type SocketIOServerAdapter struct {
+	conn      *websocket.Conn
+	namespace string
+}
+
+type sioPacket struct {
+	Type      byte
+	Namespace string
+	Data      json.RawMessage
+}
+
+func newSocketIOServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	sio := &SocketIOServerAdapter{conn: conn}
+	if err := sio.Startup(); err != nil {
+		return nil, err
+	}
+	return sio, nil
+}
+
+func (sio *SocketIOServerAdapter) Startup() error {
+	sio.namespace = SocketIOConfig.Namespace
+	if sio.namespace == "" {
+		sio.namespace = "/"
+	}
+
+	var open string
+	if err := websocket.Message.Receive(sio.conn, &open); err != nil {
+		return err
+	}
+
+	if len(open) == 0 || open[0] != eioOpen {
+		return fmt.Errorf("expected open packet, got %q", open)
+	}
+
+	if err := sio.send(sioConnect, SocketIOConfig.Auth); err != nil {
+		return err
+	}
+
+	for {
+		packet, err := sio.receiveIgnoringPing()
+		if err != nil {
+			return err
+		}
+
+		if packet.Namespace != sio.namespace {
+			continue
+		}
+
+		switch packet.Type {
+		case sioConnect:
+			return nil
+		case sioConnectError:
+			return fmt.Errorf("namespace connection rejected: %s", packet.Data)
+		default:
+			return fmt.Errorf("expected connect packet, got %v", packet)
+		}
+	}
+}
+
+func (sio *SocketIOServerAdapter) SendEcho(payload *Payload) error {
+	return sio.emit("echo", payload)
+}
+
+func (sio *SocketIOServerAdapter) SendBroadcast(payload *Payload) error {
+	return sio.emit("broadcast", payload)
+}
+
+func (sio *SocketIOServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		packet, err := sio.receiveIgnoringPing()
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.Namespace != sio.namespace {
+			continue
+		}
+
+		switch packet.Type {
+		case sioEvent:
+		case sioDisconnect:
+			return nil, errors.New("disconnected by server")
+		default:
+			continue
+		}
+
+		var args []json.RawMessage
+		if err := json.Unmarshal(packet.Data, &args); err != nil {
+			return nil, err
+		}
+
+		if len(args) < 2 {
+			return nil, fmt.Errorf("unexpected event, got %s", packet.Data)
+		}
+
+		var event string
+		if err := json.Unmarshal(args[0], &event); err != nil {
+			return nil, err
+		}
+
+		msgType, err := ParseMessageType(event)
+		if err != nil {
+			return nil, err
+		}
+
+		var jp jsonPayload
+		if err := json.Unmarshal(args[1], &jp); err != nil {
+			return nil, err
+		}
+
+		payload, err := jsonPayloadToPayload(&jp)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ServerSentMsg{Type: msgType, Payload: payload}, nil
+	}
+}
+
+func (sio *SocketIOServerAdapter) emit(event string, payload *Payload) error {
+	data, err := json.Marshal([]interface{}{event, payloadTojsonPayload(payload)})
+	if err != nil {
+		return err
+	}
+
+	return sio.send(sioEvent, string(data))
+}
+
+func (sio *SocketIOServerAdapter) send(packetType byte, data string) error {
+	var b strings.Builder
+
+	b.WriteByte(eioMessage)
+	b.WriteByte(packetType)
+
+	if sio.namespace != "/" {
+		b.WriteString(sio.namespace)
+		b.WriteByte(',')
+	}
+
+	b.WriteString(data)
+
+	return websocket.Message.Send(sio.conn, b.String())
+}
+
+func (sio *SocketIOServerAdapter) receiveIgnoringPing() (*sioPacket, error) {
+	for {
+		var msg string
+		if err := websocket.Message.Receive(sio.conn, &msg); err != nil {
+			return nil, err
+		}
+
+		if len(msg) == 0 {
+			continue
+		}
+
+		switch msg[0] {
+		case eioPing:
+			if err := websocket.Message.Send(sio.conn, string(eioPong)+msg[1:]); err != nil {
+				return nil, err
+			}
+			continue
+		case eioClose:
+			return nil, errors.New("connection closed by server")
+		case eioMessage:
+			return parseSocketIOPacket(msg[1:])
+		default:
+			continue
+		}
+	}
+}
+
+// parseSocketIOPacket parses <type>[/<namespace>,][<ack id>][<data>]
+func parseSocketIOPacket(msg string) (*sioPacket, error) {
+	if len(msg) == 0 {
+		return nil, errors.New("empty Socket.IO packet")
+	}
+
+	packet := &sioPacket{Type: msg[0], Namespace: "/"}
+	msg = msg[1:]
+
+	if strings.HasPrefix(msg, "/") {
+		end := strings.IndexByte(msg, ',')
+		if end < 0 {
+			packet.Namespace = msg
+			return packet, nil
+		}
+		packet.Namespace = msg[:end]
+		msg = msg[end+1:]
+	}
+
+	msg = strings.TrimLeft(msg, "0123456789")
+
+	if len(msg) > 0 {
+		packet.Data = json.RawMessage(msg)
+	}
+
+	return packet, nil
+}
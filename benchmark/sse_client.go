@@ -0,0 +1,264 @@
+package benchmark
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEClientPool creates Server-Sent Events (text/event-stream) receivers.
+//
+// SSE clients can't send messages, so broadcasts are triggered by a publisher:
+// either an HTTP POST of the JSON message ({"type":"broadcast","payload":{...}})
+// to the publish URL, or a WebSocket client connected to the benchmark URL
+// with the configured server type. The publisher response (or the broadcast
+// result) is used to measure the broadcast RTT; every received event with a
+// sendTime is counted as a broadcast, and its delivery latency is reported as
+// the "sse_broadcast" step metric.
+type SSEClientPool struct {
+	laddr      *net.TCPAddr
+	url        string
+	publishURL string
+
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	clients   map[int]*sseClient
+	publisher Client
+}
+
+type sseClient struct {
+	pool    *SSEClientPool
+	body    io.ReadCloser
+	padding []byte
+
+	rttResultChan chan<- time.Duration
+	errChan       chan<- error
+
+	rxBroadcastCountLock sync.Mutex
+	rxBroadcastCount     int
+}
+
+func NewSSEClientPool(laddr *net.TCPAddr, url, publishURL string) *SSEClientPool {
+	dialer := &net.Dialer{Timeout: ConnectionTimeout}
+	if laddr != nil {
+		dialer.LocalAddr = laddr
+	}
+
+	return &SSEClientPool{
+		laddr:      laddr,
+		url:        url,
+		publishURL: publishURL,
+		clients:    make(map[int]*sseClient),
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext:     dialer.DialContext,
+				TLSClientConfig: baseTLSConfig.Clone(),
+			},
+		},
+	}
+}
+
+func (scp *SSEClientPool) New(
+	id int,
+	dest, origin, serverType string,
+	rttResultChan chan time.Duration,
+	errChan chan error,
+	padding []byte,
+) (Client, error) {
+	if scp.publishURL == "" {
+		if err := scp.startPublisher(dest, origin, serverType, rttResultChan, errChan, padding); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest("GET", scp.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+
+	res, err := scp.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected SSE response status: %s", res.Status)
+	}
+
+	if contentType := res.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected SSE response content type: %s", contentType)
+	}
+
+	c := &sseClient{
+		pool:          scp,
+		body:          res.Body,
+		padding:       padding,
+		rttResultChan: rttResultChan,
+		errChan:       errChan,
+	}
+
+	scp.mu.Lock()
+	scp.clients[id] = c
+	scp.mu.Unlock()
+
+	go c.rx(bufio.NewReader(res.Body))
+
+	return c, nil
+}
+
+// startPublisher connects the WebSocket client publishing broadcasts for all the SSE clients
+func (scp *SSEClientPool) startPublisher(
+	dest, origin, serverType string,
+	rttResultChan chan time.Duration,
+	errChan chan error,
+	padding []byte,
+) error {
+	scp.mu.Lock()
+	defer scp.mu.Unlock()
+
+	if scp.publisher != nil {
+		return nil
+	}
+
+	publisher, err := newLocalClient(-1, nil, scp.laddr, nil, dest, origin, serverType, rttResultChan, errChan, padding)
+	if err != nil {
+		return err
+	}
+
+	scp.publisher = publisher
+	return nil
+}
+
+func (scp *SSEClientPool) Close() error {
+	scp.mu.Lock()
+	defer scp.mu.Unlock()
+
+	for _, c := range scp.clients {
+		if err := c.body.Close(); err != nil {
+			return err
+		}
+	}
+
+	if publisher, ok := scp.publisher.(*localClient); ok {
+		return publisher.conn.Close()
+	}
+
+	return nil
+}
+
+func (c *sseClient) SendEcho() error {
+	return errors.New("SSE clients don't support echo")
+}
+
+func (c *sseClient) SendBroadcast() error {
+	if c.pool.publishURL == "" {
+		return c.pool.publisher.SendBroadcast()
+	}
+
+	payload := &Payload{SendTime: time.Now(), Padding: c.padding}
+
+	body, err := json.Marshal(&ssaMsg{Type: "broadcast", Payload: payloadTojsonPayload(payload)})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		res, err := c.pool.httpClient.Post(c.pool.publishURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			c.errChan <- err
+			return
+		}
+		res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			c.errChan <- fmt.Errorf("broadcast publish failed: %s", res.Status)
+			return
+		}
+
+		c.rttResultChan <- time.Since(payload.SendTime)
+	}()
+
+	return nil
+}
+
+func (c *sseClient) ResetRxBroadcastCount() (int, error) {
+	c.rxBroadcastCountLock.Lock()
+	count := c.rxBroadcastCount
+	c.rxBroadcastCount = 0
+	c.rxBroadcastCountLock.Unlock()
+	return count, nil
+}
+
+func (c *sseClient) Reconnect() (time.Duration, error) {
+	return 0, errors.New("SSE clients don't support session recovery")
+}
+
+func (c *sseClient) rx(r *bufio.Reader) {
+	var data bytes.Buffer
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			c.errChan <- err
+			return
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		// An empty line dispatches the event
+		if len(line) == 0 {
+			if data.Len() > 0 {
+				c.handleEvent(data.Bytes())
+				data.Reset()
+			}
+			continue
+		}
+
+		if bytes.HasPrefix(line, []byte("data:")) {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" ")))
+		}
+	}
+}
+
+// handleEvent counts the events carrying a broadcast payload; others (e.g. pings) are ignored
+func (c *sseClient) handleEvent(data []byte) {
+	var msg interface{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+
+	sendTime, ok := findSendTime(msg)
+	if !ok {
+		return
+	}
+
+	payload, err := stringToBinaryPayload(sendTime, "")
+	if err != nil {
+		return
+	}
+
+	stepMetrics.AddLatency("sse_broadcast", time.Since(payload.SendTime))
+
+	c.rxBroadcastCountLock.Lock()
+	c.rxBroadcastCount++
+	c.rxBroadcastCountLock.Unlock()
+}
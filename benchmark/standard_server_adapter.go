@@ -10,6 +10,15 @@ type StandardServerAdapter struct {
 	conn *websocket.Conn
 }
 
+func init() {
+	RegisterServerType(&ServerType{
+		Name: "json",
+		New: func(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+			return &StandardServerAdapter{conn: conn}, nil
+		},
+	})
+}
+
 type ssaMsg struct {
 	Type    string       `json:"type"`
 	Payload *jsonPayload `json:"payload"`
@@ -23,14 +32,14 @@ func (ssa *StandardServerAdapter) SendBroadcast(payload *Payload) error {
 	return websocket.JSON.Send(ssa.conn, &ssaMsg{Type: "broadcast", Payload: payloadTojsonPayload(payload)})
 }
 
-func (ssa *StandardServerAdapter) Receive() (*serverSentMsg, error) {
+func (ssa *StandardServerAdapter) Receive() (*ServerSentMsg, error) {
 	var jsonMsg jsonServerSentMsg
 	err := websocket.JSON.Receive(ssa.conn, &jsonMsg)
 	if err != nil {
 		return nil, err
 	}
 
-	var msg serverSentMsg
+	var msg ServerSentMsg
 	msg.Type, err = ParseMessageType(jsonMsg.Type)
 	if err != nil {
 		return nil, err
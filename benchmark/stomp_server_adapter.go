@@ -0,0 +1,430 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var StompConfig struct {
+	Destination          string
+	BroadcastDestination string
+	EchoDestination      string
+	Login                string
+	Passcode             string
+	Host                 string
+	Heartbeat            time.Duration
+}
+
+func init() {
+	flags := pflag.NewFlagSet("stomp", pflag.ContinueOnError)
+	flags.StringVar(&StompConfig.Destination, "stomp-destination", "/topic/benchmark", "STOMP destination to subscribe to")
+	flags.StringVar(&StompConfig.BroadcastDestination, "stomp-broadcast-destination", "", "STOMP destination to send broadcasts to (defaults to --stomp-destination)")
+	flags.StringVar(&StompConfig.EchoDestination, "stomp-echo-destination", "", "STOMP destination to send echoes to (defaults to a private queue per client)")
+	flags.StringVar(&StompConfig.Login, "stomp-login", "", "STOMP login")
+	flags.StringVar(&StompConfig.Passcode, "stomp-passcode", "", "STOMP passcode")
+	flags.StringVar(&StompConfig.Host, "stomp-host", "", "STOMP virtual host (defaults to the URL host)")
+	flags.DurationVar(&StompConfig.Heartbeat, "stomp-heartbeat", 0, "STOMP heart-beat interval (0 to disable)")
+
+	RegisterServerType(&ServerType{
+		Name:  "stomp",
+		New:   newStompServerAdapter,
+		Flags: flags,
+		Handshake: func(config *websocket.Config) {
+			config.Protocol = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+		},
+	})
+}
+
+// StompServerAdapter speaks STOMP 1.0-1.2 over WebSocket.
+//
+// Every client subscribes to the configured destination and to the echo
+// destination (a private queue by default). Messages carry a JSON body with
+// an action ("echo" or "broadcast") and the payload. Broadcasts are sent with
+// a receipt, and the RECEIPT frame is used to measure the broadcast RTT; the
+// subscriptions are confirmed with receipts too.
+type StompServerAdapter struct {
+	conn *websocket.Conn
+
+	echoDestination string
+
+	mu      sync.Mutex
+	lastID  int
+	pending map[string]*Payload
+
+	frames []*stompFrame
+	done   chan struct{}
+}
+
+type stompFrame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+type stompMsg struct {
+	Action  string       `json:"action"`
+	Payload *jsonPayload `json:"payload"`
+}
+
+func newStompServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	sta := &StompServerAdapter{conn: conn}
+	if err := sta.Startup(); err != nil {
+		return nil, err
+	}
+	return sta, nil
+}
+
+func (sta *StompServerAdapter) Startup() error {
+	sta.pending = make(map[string]*Payload)
+	sta.done = make(chan struct{})
+
+	sta.echoDestination = StompConfig.EchoDestination
+	if sta.echoDestination == "" {
+		sta.echoDestination = fmt.Sprintf("/queue/websocket-bench-%d", rand.Int63())
+	}
+
+	host := StompConfig.Host
+	if host == "" {
+		host = RemoteAddr.Host
+	}
+
+	heartbeat := strconv.FormatInt(int64(StompConfig.Heartbeat/time.Millisecond), 10)
+
+	connect := &stompFrame{Command: "CONNECT", Headers: map[string]string{
+		"accept-version": "1.2,1.1,1.0",
+		"host":           host,
+		"heart-beat":     heartbeat + "," + heartbeat,
+	}}
+	if StompConfig.Login != "" {
+		connect.Headers["login"] = StompConfig.Login
+		connect.Headers["passcode"] = StompConfig.Passcode
+	}
+
+	if err := sta.send(connect); err != nil {
+		return err
+	}
+
+	connected, err := sta.receiveFrame()
+	if err != nil {
+		return err
+	}
+
+	if connected.Command != "CONNECTED" {
+		return fmt.Errorf("expected CONNECTED frame, got %s: %s", connected.Command, connected.Body)
+	}
+
+	if interval := stompHeartbeatInterval(connected.Headers["heart-beat"]); interval > 0 {
+		go sta.heartbeat(interval)
+	}
+
+	receipts := make(map[string]bool)
+	for i, destination := range []string{StompConfig.Destination, sta.echoDestination} {
+		id := "sub-" + strconv.Itoa(i)
+		err := sta.send(&stompFrame{Command: "SUBSCRIBE", Headers: map[string]string{
+			"id":          id,
+			"destination": destination,
+			"ack":         "auto",
+			"receipt":     id,
+		}})
+		if err != nil {
+			return err
+		}
+		receipts[id] = true
+	}
+
+	return sta.awaitReceipts(receipts)
+}
+
+// awaitReceipts waits for the subscription receipts, so that the broadcasts
+// aren't sent before the broker activates the subscriptions. The other frames
+// received meanwhile are kept for Receive.
+func (sta *StompServerAdapter) awaitReceipts(receipts map[string]bool) error {
+	var skipped []*stompFrame
+
+	for len(receipts) > 0 {
+		frame, err := sta.receiveFrame()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case frame.Command == "RECEIPT" && receipts[frame.Headers["receipt-id"]]:
+			delete(receipts, frame.Headers["receipt-id"])
+		case frame.Command == "ERROR":
+			return fmt.Errorf("subscription failed: %s %s", frame.Headers["message"], frame.Body)
+		default:
+			skipped = append(skipped, frame)
+		}
+	}
+
+	sta.frames = append(skipped, sta.frames...)
+
+	return nil
+}
+
+func (sta *StompServerAdapter) SendEcho(payload *Payload) error {
+	body, err := json.Marshal(&stompMsg{Action: "echo", Payload: payloadTojsonPayload(payload)})
+	if err != nil {
+		return err
+	}
+
+	return sta.send(&stompFrame{
+		Command: "SEND",
+		Headers: map[string]string{
+			"destination":  sta.echoDestination,
+			"content-type": "application/json",
+		},
+		Body: body,
+	})
+}
+
+func (sta *StompServerAdapter) SendBroadcast(payload *Payload) error {
+	body, err := json.Marshal(&stompMsg{Action: "broadcast", Payload: payloadTojsonPayload(payload)})
+	if err != nil {
+		return err
+	}
+
+	destination := StompConfig.BroadcastDestination
+	if destination == "" {
+		destination = StompConfig.Destination
+	}
+
+	sta.mu.Lock()
+	sta.lastID++
+	receipt := strconv.Itoa(sta.lastID)
+	sta.pending[receipt] = payload
+	sta.mu.Unlock()
+
+	return sta.send(&stompFrame{
+		Command: "SEND",
+		Headers: map[string]string{
+			"destination":  destination,
+			"content-type": "application/json",
+			"receipt":      receipt,
+		},
+		Body: body,
+	})
+}
+
+func (sta *StompServerAdapter) Receive() (*ServerSentMsg, error) {
+	for {
+		frame, err := sta.receiveFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch frame.Command {
+		case "RECEIPT":
+			sta.mu.Lock()
+			payload, ok := sta.pending[frame.Headers["receipt-id"]]
+			delete(sta.pending, frame.Headers["receipt-id"])
+			sta.mu.Unlock()
+
+			if !ok {
+				return nil, fmt.Errorf("unexpected receipt: %v", frame.Headers)
+			}
+
+			return &ServerSentMsg{Type: MsgServerBroadcastResult, Payload: payload}, nil
+		case "MESSAGE":
+			var msg stompMsg
+			if err := json.Unmarshal(frame.Body, &msg); err != nil {
+				return nil, err
+			}
+
+			if msg.Payload == nil {
+				return nil, fmt.Errorf("received message without payload: %s", frame.Body)
+			}
+
+			// Skip the messages with other actions (e.g. published to the destination
+			// by other applications)
+			if msg.Action != "echo" && msg.Action != "broadcast" {
+				continue
+			}
+
+			msgType, err := ParseMessageType(msg.Action)
+			if err != nil {
+				return nil, err
+			}
+
+			payload, err := jsonPayloadToPayload(msg.Payload)
+			if err != nil {
+				return nil, err
+			}
+
+			return &ServerSentMsg{Type: msgType, Payload: payload}, nil
+		case "ERROR":
+			return nil, fmt.Errorf("server error: %s %s", frame.Headers["message"], frame.Body)
+		}
+	}
+}
+
+func (sta *StompServerAdapter) send(frame *stompFrame) error {
+	var buf bytes.Buffer
+
+	buf.WriteString(frame.Command)
+	buf.WriteByte('\n')
+	for k, v := range frame.Headers {
+		// CONNECT headers are not escaped
+		if frame.Command != "CONNECT" {
+			k, v = stompEscape(k), stompEscape(v)
+		}
+		buf.WriteString(k)
+		buf.WriteByte(':')
+		buf.WriteString(v)
+		buf.WriteByte('\n')
+	}
+	if len(frame.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(frame.Body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(frame.Body)
+	buf.WriteByte(0)
+
+	return websocket.Message.Send(sta.conn, buf.String())
+}
+
+func (sta *StompServerAdapter) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sta.done:
+			return
+		case <-ticker.C:
+			if err := websocket.Message.Send(sta.conn, "\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// receiveFrame returns the next frame skipping heart-beats; a WebSocket
+// message may contain several frames.
+func (sta *StompServerAdapter) receiveFrame() (*stompFrame, error) {
+	for len(sta.frames) == 0 {
+		var data []byte
+		if err := websocket.Message.Receive(sta.conn, &data); err != nil {
+			sta.stopHeartbeat()
+			return nil, err
+		}
+
+		frames, err := parseStompFrames(data)
+		if err != nil {
+			return nil, err
+		}
+		sta.frames = frames
+	}
+
+	frame := sta.frames[0]
+	sta.frames = sta.frames[1:]
+
+	return frame, nil
+}
+
+func (sta *StompServerAdapter) stopHeartbeat() {
+	select {
+	case <-sta.done:
+	default:
+		close(sta.done)
+	}
+}
+
+func parseStompFrames(data []byte) ([]*stompFrame, error) {
+	var frames []*stompFrame
+
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+
+		frame := &stompFrame{Headers: make(map[string]string)}
+
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				return nil, errors.New("malformed STOMP frame: missing headers end")
+			}
+
+			line := strings.TrimSuffix(string(data[:i]), "\r")
+			data = data[i+1:]
+
+			if line == "" {
+				break
+			}
+
+			if frame.Command == "" {
+				frame.Command = line
+				continue
+			}
+
+			sep := strings.IndexByte(line, ':')
+			if sep < 0 {
+				continue
+			}
+			// The first header occurrence wins
+			key := stompUnescape(line[:sep])
+			if _, ok := frame.Headers[key]; !ok {
+				frame.Headers[key] = stompUnescape(line[sep+1:])
+			}
+		}
+
+		bodyEnd := bytes.IndexByte(data, 0)
+		if length, err := strconv.Atoi(frame.Headers["content-length"]); err == nil && length <= len(data) {
+			bodyEnd = length
+		}
+		if bodyEnd < 0 {
+			return nil, errors.New("malformed STOMP frame: missing NULL terminator")
+		}
+
+		frame.Body = data[:bodyEnd]
+		data = bytes.TrimPrefix(data[bodyEnd:], []byte{0})
+
+		frames = append(frames, frame)
+	}
+}
+
+// stompHeartbeatInterval returns how often the client must send heart-beats
+// according to the server CONNECTED heart-beat header
+func stompHeartbeatInterval(header string) time.Duration {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 || StompConfig.Heartbeat == 0 {
+		return 0
+	}
+
+	sy, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || sy == 0 {
+		return 0
+	}
+
+	interval := time.Duration(sy) * time.Millisecond
+	if interval < StompConfig.Heartbeat {
+		interval = StompConfig.Heartbeat
+	}
+
+	return interval
+}
+
+var stompEscaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+var stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+
+func stompEscape(s string) string {
+	return stompEscaper.Replace(s)
+}
+
+func stompUnescape(s string) string {
+	return stompUnescaper.Replace(s)
+}
@@ -0,0 +1,339 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/websocket"
+)
+
+var TemplateConfig struct {
+	File string
+}
+
+func init() {
+	flags := pflag.NewFlagSet("template", pflag.ContinueOnError)
+	flags.StringVar(&TemplateConfig.File, "template-file", "", "message template file for the template server type (JSON)")
+
+	RegisterServerType(&ServerType{
+		Name:      "template",
+		New:       newTemplateServerAdapter,
+		Flags:     flags,
+		Configure: configureTemplate,
+		Handshake: func(config *websocket.Config) {
+			if len(messageTemplate.Subprotocols) > 0 {
+				config.Protocol = messageTemplate.Subprotocols
+			}
+		},
+	})
+}
+
+// MessageTemplate describes a protocol for the template server type.
+//
+// Messages are JSON values sent as text frames; a JSON string is sent as is,
+// without quotes. The {{sendTime}}, {{clientID}} and {{padding}} placeholders
+// are substituted in the messages. For example:
+//
+//	{
+//	  "subprotocols": ["actioncable-v1-json"],
+//	  "handshake": [
+//	    {"expect": {"$.type": "welcome"}},
+//	    {"send": {"command": "subscribe", "identifier": "{\"channel\":\"BenchmarkChannel\"}"}},
+//	    {"expect": {"$.type": "confirm_subscription"}}
+//	  ],
+//	  "echo": {
+//	    "command": "message",
+//	    "identifier": "{\"channel\":\"BenchmarkChannel\"}",
+//	    "data": "{\"action\":\"echo\",\"payload\":{\"sendTime\":\"{{sendTime}}\",\"padding\":\"{{padding}}\"}}"
+//	  },
+//	  "broadcast": {
+//	    "command": "message",
+//	    "identifier": "{\"channel\":\"BenchmarkChannel\"}",
+//	    "data": "{\"action\":\"broadcast\",\"payload\":{\"sendTime\":\"{{sendTime}}\",\"padding\":\"{{padding}}\"}}"
+//	  },
+//	  "receive": {
+//	    "type": "$.message.action",
+//	    "sendTime": "$.message.payload.sendTime",
+//	    "types": {"echo": "echo", "broadcast": "broadcast", "broadcastResult": "broadcastResult"}
+//	  }
+//	}
+//
+// Received messages are matched with JSONPath-style extractors ($.key.nested[0]).
+// Handshake "expect" steps skip messages until all the extracted values match.
+// The received messages without the type (e.g. the Action Cable pings) are
+// skipped. The types are mapped with "types" (echo, broadcast and
+// broadcastResult map to themselves if it's omitted); the types listed in
+// "ignore" are skipped too, and any other message is an error.
+type MessageTemplate struct {
+	Subprotocols []string            `json:"subprotocols"`
+	Handshake    []*TemplateStep     `json:"handshake"`
+	Echo         json.RawMessage     `json:"echo"`
+	Broadcast    json.RawMessage     `json:"broadcast"`
+	Receive      *TemplateExtractors `json:"receive"`
+}
+
+type TemplateStep struct {
+	Send   json.RawMessage   `json:"send,omitempty"`
+	Expect map[string]string `json:"expect,omitempty"`
+}
+
+type TemplateExtractors struct {
+	// Type is the path to the message type
+	Type string `json:"type"`
+	// SendTime is the path to the send time (Unix nanoseconds)
+	SendTime string `json:"sendTime"`
+	// Types maps the extracted message types to echo, broadcast or broadcastResult
+	Types map[string]string `json:"types"`
+	// Ignore are the extracted message types to skip
+	Ignore []string `json:"ignore"`
+}
+
+var messageTemplate MessageTemplate
+
+func configureTemplate() error {
+	if TemplateConfig.File == "" {
+		return errors.New("--template-file is required for the template server type")
+	}
+
+	data, err := ioutil.ReadFile(TemplateConfig.File)
+	if err != nil {
+		return err
+	}
+
+	var tmpl MessageTemplate
+	if err := json.Unmarshal(data, &tmpl); err != nil {
+		return fmt.Errorf("failed to parse message template: %v", err)
+	}
+
+	if tmpl.Receive == nil || tmpl.Receive.Type == "" || tmpl.Receive.SendTime == "" {
+		return errors.New("message template must define the receive type and sendTime extractors")
+	}
+
+	if len(tmpl.Receive.Types) == 0 {
+		tmpl.Receive.Types = map[string]string{
+			"echo":            "echo",
+			"broadcast":       "broadcast",
+			"broadcastResult": "broadcastResult",
+		}
+	}
+
+	for _, msgType := range tmpl.Receive.Types {
+		if _, err := ParseMessageType(msgType); err != nil {
+			return err
+		}
+	}
+
+	messageTemplate = tmpl
+	return nil
+}
+
+// TemplateServerAdapter speaks the protocol described by the message template
+type TemplateServerAdapter struct {
+	conn     *websocket.Conn
+	clientID int
+}
+
+func newTemplateServerAdapter(conn *websocket.Conn, client *ClientInfo) (ServerAdapter, error) {
+	tsa := &TemplateServerAdapter{conn: conn, clientID: client.ID}
+	if err := tsa.Startup(); err != nil {
+		return nil, err
+	}
+	return tsa, nil
+}
+
+func (tsa *TemplateServerAdapter) Startup() error {
+	for _, step := range messageTemplate.Handshake {
+		if len(step.Send) > 0 {
+			if err := tsa.send(step.Send, nil); err != nil {
+				return err
+			}
+		}
+
+		if len(step.Expect) > 0 {
+			if err := tsa.expect(step.Expect); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (tsa *TemplateServerAdapter) SendEcho(payload *Payload) error {
+	return tsa.send(messageTemplate.Echo, payload)
+}
+
+func (tsa *TemplateServerAdapter) SendBroadcast(payload *Payload) error {
+	return tsa.send(messageTemplate.Broadcast, payload)
+}
+
+func (tsa *TemplateServerAdapter) Receive() (*ServerSentMsg, error) {
+	extractors := messageTemplate.Receive
+
+	for {
+		msg, err := tsa.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := jsonPathLookup(msg, extractors.Type)
+		if !ok {
+			debug(fmt.Sprintf("skipping message without type at %s: %s", extractors.Type, rawMessage(msg)))
+			continue
+		}
+
+		if containsString(extractors.Ignore, value) {
+			continue
+		}
+
+		typeName, ok := extractors.Types[value]
+		if !ok {
+			return nil, fmt.Errorf("unmapped message type %q: %s", value, rawMessage(msg))
+		}
+
+		msgType, err := ParseMessageType(typeName)
+		if err != nil {
+			return nil, err
+		}
+
+		sendTime, ok := jsonPathLookup(msg, extractors.SendTime)
+		if !ok {
+			return nil, fmt.Errorf("send time not found at %s", extractors.SendTime)
+		}
+
+		payload, err := stringToBinaryPayload(sendTime, "")
+		if err != nil {
+			return nil, err
+		}
+
+		return &ServerSentMsg{Type: msgType, Payload: payload}, nil
+	}
+}
+
+// expect skips messages until the one matching all the extractors
+func (tsa *TemplateServerAdapter) expect(matchers map[string]string) error {
+	for {
+		msg, err := tsa.receive()
+		if err != nil {
+			return err
+		}
+
+		matched := true
+		for path, expected := range matchers {
+			if value, ok := jsonPathLookup(msg, path); !ok || value != expected {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return nil
+		}
+	}
+}
+
+func (tsa *TemplateServerAdapter) send(tmpl json.RawMessage, payload *Payload) error {
+	msg := string(tmpl)
+
+	var s string
+	if err := json.Unmarshal(tmpl, &s); err == nil {
+		msg = s
+	}
+
+	replacements := []string{"{{clientID}}", strconv.Itoa(tsa.clientID)}
+	if payload != nil {
+		replacements = append(replacements,
+			"{{sendTime}}", strconv.FormatInt(payload.SendTime.UnixNano(), 10),
+			"{{padding}}", string(payload.Padding),
+		)
+	}
+
+	return websocket.Message.Send(tsa.conn, strings.NewReplacer(replacements...).Replace(msg))
+}
+
+func (tsa *TemplateServerAdapter) receive() (interface{}, error) {
+	var data []byte
+	if err := websocket.Message.Receive(tsa.conn, &data); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var msg interface{}
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, fmt.Errorf("failed to parse message %s: %v", data, err)
+	}
+
+	return msg, nil
+}
+
+// rawMessage returns the received message JSON for the error messages
+func rawMessage(msg interface{}) string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%v", msg)
+	}
+	return string(data)
+}
+
+// jsonPathLookup returns the string representation of the value at the
+// JSONPath-style path, e.g. $.message.payload.sendTime or $.args[0].sendTime
+func jsonPathLookup(doc interface{}, path string) (string, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.Replace(path, "[", ".[", -1)
+
+	value := doc
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+
+		if strings.HasPrefix(key, "[") && strings.HasSuffix(key, "]") {
+			list, ok := value.([]interface{})
+			if !ok {
+				return "", false
+			}
+
+			i, err := strconv.Atoi(key[1 : len(key)-1])
+			if err != nil || i < 0 || i >= len(list) {
+				return "", false
+			}
+
+			value = list[i]
+			continue
+		}
+
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		if value, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", false
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+}
@@ -0,0 +1,134 @@
+package benchmark
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+var TLSConfig struct {
+	// Verify enables the server certificate verification (implied by CAFile)
+	Verify   bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version (1.0, 1.1, 1.2 or 1.3)
+	MinVersion string
+	// Ciphers are the TLS 1.0-1.2 cipher suite names in the preference order
+	Ciphers []string
+	ALPN    []string
+	// SessionTickets enables the session resumption with tickets
+	SessionTickets bool
+}
+
+// baseTLSConfig is built from TLSConfig by ConfigureTLS
+var baseTLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ConfigureTLS validates TLSConfig and loads the CA bundle and the client certificate
+func ConfigureTLS() error {
+	config := &tls.Config{
+		InsecureSkipVerify: !TLSConfig.Verify && TLSConfig.CAFile == "",
+		NextProtos:         TLSConfig.ALPN,
+	}
+
+	if TLSConfig.CAFile != "" {
+		data, err := ioutil.ReadFile(TLSConfig.CAFile)
+		if err != nil {
+			return err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", TLSConfig.CAFile)
+		}
+	}
+
+	if TLSConfig.CertFile != "" || TLSConfig.KeyFile != "" {
+		if TLSConfig.CertFile == "" || TLSConfig.KeyFile == "" {
+			return errors.New("both TLS client certificate and key files are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(TLSConfig.CertFile, TLSConfig.KeyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if TLSConfig.MinVersion != "" {
+		version, ok := tlsVersions[TLSConfig.MinVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version: %s", TLSConfig.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	for _, name := range TLSConfig.Ciphers {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return fmt.Errorf("unknown TLS cipher suite: %s", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	if TLSConfig.SessionTickets {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		config.SessionTicketsDisabled = true
+	}
+
+	baseTLSConfig = config
+	return nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if strings.EqualFold(suite.Name, name) {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// tlsHandshake establishes the TLS connection, reporting the handshake
+// duration and whether the session was resumed as step metrics
+func tlsHandshake(conn net.Conn, host string, alpn ...string) (*tls.Conn, error) {
+	config := baseTLSConfig.Clone()
+	config.ServerName = host
+	if len(alpn) > 0 {
+		config.NextProtos = alpn
+	}
+
+	tlsConn := tls.Client(conn, config)
+
+	start := time.Now()
+
+	conn.SetDeadline(start.Add(ConnectionTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, classify(ErrorClassTLSFailure, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	stepMetrics.AddPreciseLatency("tls_handshake", time.Since(start))
+	stepMetrics.Add("tls_handshakes", 1)
+	if tlsConn.ConnectionState().DidResume {
+		stepMetrics.Add("tls_resumed", 1)
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,116 @@
+package benchmark
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+)
+
+const turboStreamsChannel = "Turbo::StreamsChannel"
+
+// turboStreamTemplate is the parsed CableConfig.TurboStream (nil if not set)
+var turboStreamTemplate *template.Template
+
+// turboStreamData is passed to the stream name template
+type turboStreamData struct {
+	// ID is the client ID
+	ID int
+	// Group is the client group number (ID modulo the number of groups)
+	Group int
+}
+
+// configureTurboStreams parses the stream name template once for all the clients
+func configureTurboStreams() error {
+	turboStreamTemplate = nil
+
+	if CableConfig.TurboStream == "" {
+		return nil
+	}
+
+	// The server rejects the names signed with another key without telling why
+	if CableConfig.TurboSecret == "" {
+		return errors.New("--turbo-stream requires --turbo-secret to sign the stream names")
+	}
+
+	tmpl, err := template.New("stream").Parse(CableConfig.TurboStream)
+	if err != nil {
+		return fmt.Errorf("invalid --turbo-stream template: %v", err)
+	}
+	turboStreamTemplate = tmpl
+
+	return nil
+}
+
+// cableIdentifier returns the channel identifier to subscribe the client to:
+// the identity channel, the static CableConfig.Channel or a Turbo Streams
+// channel with the signed stream name generated from CableConfig.TurboStream
+func cableIdentifier(clientID int, identity Identity) (string, error) {
+	if identity["channel"] != "" {
+		return identity["channel"], nil
+	}
+
+	if turboStreamTemplate == nil {
+		return CableConfig.Channel, nil
+	}
+
+	data := turboStreamData{ID: clientID, Group: clientID}
+	if CableConfig.TurboStreamGroups > 0 {
+		data.Group = clientID % CableConfig.TurboStreamGroups
+	}
+
+	var stream bytes.Buffer
+	if err := turboStreamTemplate.Execute(&stream, data); err != nil {
+		return "", err
+	}
+
+	signed, err := signTurboStreamName(stream.String(), CableConfig.TurboSecret)
+	if err != nil {
+		return "", err
+	}
+
+	identifier, err := marshalUnescapedJSON(map[string]string{
+		"channel":            turboStreamsChannel,
+		"signed_stream_name": signed,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(identifier), nil
+}
+
+// signTurboStreamName signs the stream name the same way as Turbo does
+// (ActiveSupport::MessageVerifier with the SHA256 digest and the JSON serializer)
+func signTurboStreamName(stream, secret string) (string, error) {
+	data, err := marshalUnescapedJSON(stream)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+
+	return encoded + "--" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// marshalUnescapedJSON encodes the value without escaping <, > and & (which
+// json.Marshal does), so the signed stream names match the ones Rails generates
+func marshalUnescapedJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
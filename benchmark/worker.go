@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
@@ -16,13 +17,23 @@ type WorkerMsg struct {
 	RTTResult        *WorkerRTTResultMsg        `json:"rttResult,omitempty"`
 	Error            *WorkerErrorMsg            `json:"error,omitempty"`
 	RxBroadcastCount *WorkerRxBroadcastCountMsg `json:"rxBroadcastCount,omitempty"`
+	Reconnect        *WorkerReconnectMsg        `json:"reconnect,omitempty"`
 }
 
 type WorkerConnectMsg struct {
 	Dest       string
 	Origin     string
 	ServerType string
-	Padding    []byte
+	// ServerOptions are the server type flags values
+	ServerOptions map[string]string
+	Padding       []byte
+	// Header, Cookies and Query are the handshake options (see HandshakeConfig)
+	Header  http.Header
+	Cookies []string
+	Query   string
+	JWT     JWTOptions
+	// Identity is the client data assigned by the benchmark
+	Identity Identity
 }
 
 type WorkerRTTResultMsg struct {
@@ -31,12 +42,19 @@ type WorkerRTTResultMsg struct {
 
 type WorkerErrorMsg struct {
 	Msg string
+	// Class is the error class the error is reported under
+	Class string
 }
 
 type WorkerRxBroadcastCountMsg struct {
 	Count int
 }
 
+type WorkerReconnectMsg struct {
+	Duration time.Duration
+	Error    string
+}
+
 type Worker struct {
 	listener net.Listener
 	laddr    string
@@ -45,9 +63,12 @@ type Worker struct {
 type workerConn struct {
 	conn        net.Conn
 	encoder     *json.Encoder
-	clientPools []ClientPool
+	clientPools []*LocalClientPool
 	clients     map[int]Client
 
+	// serverType is the server type configured with the connect options
+	serverType string
+
 	closedMutex sync.Mutex
 	closed      bool
 }
@@ -75,7 +96,7 @@ func (w *Worker) Serve() error {
 		wc := &workerConn{
 			conn:        conn,
 			encoder:     json.NewEncoder(conn),
-			clientPools: []ClientPool{NewLocalClientPool(nil)},
+			clientPools: []*LocalClientPool{NewLocalClientPool(nil)},
 			clients:     make(map[int]Client),
 		}
 
@@ -107,7 +128,7 @@ func (wc *workerConn) rx(clientID int, rttResultChan chan time.Duration, errChan
 			msg := WorkerMsg{
 				ClientID: clientID,
 				Type:     "error",
-				Error:    &WorkerErrorMsg{Msg: err.Error()},
+				Error:    &WorkerErrorMsg{Msg: err.Error(), Class: errorClass(err)},
 			}
 
 			if err := wc.encoder.Encode(msg); err != nil {
@@ -150,11 +171,31 @@ func (wc *workerConn) work() {
 
 		switch msg.Type {
 		case "connect":
+			if wc.serverType != msg.Connect.ServerType {
+				if err := ConfigureServerType(msg.Connect.ServerType, msg.Connect.ServerOptions); err != nil {
+					log.Println(err)
+					return
+				}
+				wc.serverType = msg.Connect.ServerType
+			}
+
+			HandshakeConfig.Header = msg.Connect.Header
+			HandshakeConfig.Cookies = msg.Connect.Cookies
+			HandshakeConfig.Query = msg.Connect.Query
+
+			if JWTConfig != msg.Connect.JWT {
+				JWTConfig = msg.Connect.JWT
+				if err := ConfigureJWT(); err != nil {
+					log.Println(err)
+					return
+				}
+			}
+
 			cp := wc.clientPools[len(wc.clients)%len(wc.clientPools)]
 			rttResultChan := make(chan time.Duration)
 			errChan := make(chan error)
 
-			c, err := cp.New(msg.ClientID, msg.Connect.Dest, msg.Connect.Origin, msg.Connect.ServerType, rttResultChan, errChan, msg.Connect.Padding)
+			c, err := cp.newClient(msg.ClientID, msg.Connect.Identity, msg.Connect.Dest, msg.Connect.Origin, msg.Connect.ServerType, rttResultChan, errChan, msg.Connect.Padding)
 			if err != nil {
 				log.Println(err)
 				return
@@ -188,6 +229,26 @@ func (wc *workerConn) work() {
 				log.Fatalln(err)
 			}
 
+		case "reconnect":
+			go func(clientID int, c Client) {
+				result := &WorkerReconnectMsg{}
+				duration, err := c.Reconnect()
+				if err != nil {
+					result.Error = err.Error()
+				}
+				result.Duration = duration
+
+				msg := WorkerMsg{
+					ClientID:  clientID,
+					Type:      "reconnect",
+					Reconnect: result,
+				}
+
+				if err := wc.encoder.Encode(msg); err != nil {
+					log.Fatalln(err)
+				}
+			}(msg.ClientID, wc.clients[msg.ClientID])
+
 		default:
 			log.Println("unknown message:", msg.Type)
 		}
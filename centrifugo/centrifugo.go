@@ -0,0 +1,166 @@
+// Package centrifugo describes the subset of the Centrifuge client protocol (v2)
+// messages used by the benchmark.
+//
+// The structs carry both JSON and protobuf tags, so the same values can be
+// sent over the JSON and the protobuf ("centrifuge-protobuf") encodings.
+// Field numbers follow client.proto from github.com/centrifugal/protocol.
+package centrifugo
+
+import (
+	proto "github.com/golang/protobuf/proto"
+)
+
+// Raw is an opaque payload: raw JSON in the JSON encoding and bytes in protobuf.
+type Raw []byte
+
+func (r Raw) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+func (r *Raw) UnmarshalJSON(data []byte) error {
+	*r = append((*r)[0:0], data...)
+	return nil
+}
+
+type Error struct {
+	Code      uint32 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message   string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Temporary bool   `protobuf:"varint,3,opt,name=temporary,proto3" json:"temporary,omitempty"`
+}
+
+func (m *Error) Reset()         { *m = Error{} }
+func (m *Error) String() string { return proto.CompactTextString(m) }
+func (*Error) ProtoMessage()    {}
+
+type Command struct {
+	Id        uint32            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Connect   *ConnectRequest   `protobuf:"bytes,4,opt,name=connect,proto3" json:"connect,omitempty"`
+	Subscribe *SubscribeRequest `protobuf:"bytes,5,opt,name=subscribe,proto3" json:"subscribe,omitempty"`
+	Publish   *PublishRequest   `protobuf:"bytes,7,opt,name=publish,proto3" json:"publish,omitempty"`
+	RPC       *RPCRequest       `protobuf:"bytes,13,opt,name=rpc,proto3" json:"rpc,omitempty"`
+}
+
+func (m *Command) Reset()         { *m = Command{} }
+func (m *Command) String() string { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()    {}
+
+type Reply struct {
+	Id        uint32           `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Error     *Error           `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Push      *Push            `protobuf:"bytes,4,opt,name=push,proto3" json:"push,omitempty"`
+	Connect   *ConnectResult   `protobuf:"bytes,5,opt,name=connect,proto3" json:"connect,omitempty"`
+	Subscribe *SubscribeResult `protobuf:"bytes,6,opt,name=subscribe,proto3" json:"subscribe,omitempty"`
+	Publish   *PublishResult   `protobuf:"bytes,8,opt,name=publish,proto3" json:"publish,omitempty"`
+	RPC       *RPCResult       `protobuf:"bytes,13,opt,name=rpc,proto3" json:"rpc,omitempty"`
+}
+
+func (m *Reply) Reset()         { *m = Reply{} }
+func (m *Reply) String() string { return proto.CompactTextString(m) }
+func (*Reply) ProtoMessage()    {}
+
+// IsPing returns true for an empty reply, which the server sends as a ping.
+func (m *Reply) IsPing() bool {
+	return m.Id == 0 && m.Error == nil && m.Push == nil
+}
+
+type Push struct {
+	Channel    string       `protobuf:"bytes,2,opt,name=channel,proto3" json:"channel,omitempty"`
+	Pub        *Publication `protobuf:"bytes,4,opt,name=pub,proto3" json:"pub,omitempty"`
+	Disconnect *Disconnect  `protobuf:"bytes,11,opt,name=disconnect,proto3" json:"disconnect,omitempty"`
+}
+
+func (m *Push) Reset()         { *m = Push{} }
+func (m *Push) String() string { return proto.CompactTextString(m) }
+func (*Push) ProtoMessage()    {}
+
+type Publication struct {
+	Data   Raw    `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Offset uint64 `protobuf:"varint,6,opt,name=offset,proto3" json:"offset,omitempty"`
+}
+
+func (m *Publication) Reset()         { *m = Publication{} }
+func (m *Publication) String() string { return proto.CompactTextString(m) }
+func (*Publication) ProtoMessage()    {}
+
+type Disconnect struct {
+	Code   uint32 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (m *Disconnect) Reset()         { *m = Disconnect{} }
+func (m *Disconnect) String() string { return proto.CompactTextString(m) }
+func (*Disconnect) ProtoMessage()    {}
+
+type ConnectRequest struct {
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	Data  Raw    `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Name  string `protobuf:"bytes,4,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *ConnectRequest) Reset()         { *m = ConnectRequest{} }
+func (m *ConnectRequest) String() string { return proto.CompactTextString(m) }
+func (*ConnectRequest) ProtoMessage()    {}
+
+type ConnectResult struct {
+	Client  string `protobuf:"bytes,1,opt,name=client,proto3" json:"client,omitempty"`
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Ping    uint32 `protobuf:"varint,7,opt,name=ping,proto3" json:"ping,omitempty"`
+	Pong    bool   `protobuf:"varint,8,opt,name=pong,proto3" json:"pong,omitempty"`
+}
+
+func (m *ConnectResult) Reset()         { *m = ConnectResult{} }
+func (m *ConnectResult) String() string { return proto.CompactTextString(m) }
+func (*ConnectResult) ProtoMessage()    {}
+
+type SubscribeRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	Token   string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+
+type SubscribeResult struct {
+	Epoch  string `protobuf:"bytes,6,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Offset uint64 `protobuf:"varint,7,opt,name=offset,proto3" json:"offset,omitempty"`
+}
+
+func (m *SubscribeResult) Reset()         { *m = SubscribeResult{} }
+func (m *SubscribeResult) String() string { return proto.CompactTextString(m) }
+func (*SubscribeResult) ProtoMessage()    {}
+
+type PublishRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	Data    Raw    `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *PublishRequest) Reset()         { *m = PublishRequest{} }
+func (m *PublishRequest) String() string { return proto.CompactTextString(m) }
+func (*PublishRequest) ProtoMessage()    {}
+
+type PublishResult struct{}
+
+func (m *PublishResult) Reset()         { *m = PublishResult{} }
+func (m *PublishResult) String() string { return proto.CompactTextString(m) }
+func (*PublishResult) ProtoMessage()    {}
+
+type RPCRequest struct {
+	Data   Raw    `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Method string `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+}
+
+func (m *RPCRequest) Reset()         { *m = RPCRequest{} }
+func (m *RPCRequest) String() string { return proto.CompactTextString(m) }
+func (*RPCRequest) ProtoMessage()    {}
+
+type RPCResult struct {
+	Data Raw `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *RPCResult) Reset()         { *m = RPCResult{} }
+func (m *RPCResult) String() string { return proto.CompactTextString(m) }
+func (*RPCResult) ProtoMessage()    {}
@@ -7,7 +7,7 @@ require (
 	github.com/golang/protobuf v1.3.4
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/spf13/pflag v1.0.3
 	github.com/vmihailenco/msgpack/v5 v5.3.2
 	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
 	golang.org/x/sys v0.0.0-20190626221950-04f50cda93cb // indirect
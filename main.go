@@ -6,9 +6,10 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/anycable/websocket-bench/benchmark"
@@ -18,31 +19,68 @@ import (
 )
 
 var options struct {
-	websocketOrigin     string
-	websocketProtocol   string
-	serverType          string
-	concurrent          int
-	concurrentConnect   int
-	sampleSize          int
-	initialClients      int
-	stepSize            int
-	limitPercentile     int
-	limitRTT            time.Duration
-	payloadPaddingSize  int
-	localAddrs          []string
-	workerListenAddr    string
-	workerListenPort    int
-	workerAddrs         []string
-	totalSteps          int
-	interactive         bool
-	stepsDelay          int
-	commandDelay        float64
-	commandDelayChance  int
-	broadastsWait       int
-	channel             string
-	actionCableEncoding string
-	format              string
-	filename            string
+	websocketOrigin    string
+	websocketProtocol  string
+	serverType         string
+	concurrent         int
+	concurrentConnect  int
+	sampleSize         int
+	initialClients     int
+	stepSize           int
+	limitPercentile    int
+	limitRTT           time.Duration
+	payloadPaddingSize int
+	localAddrs         []string
+	workerListenAddr   string
+	workerListenPort   int
+	workerAddrs        []string
+	totalSteps         int
+	interactive        bool
+	stepsDelay         int
+	commandDelay       float64
+	commandDelayChance int
+	dropPercentage     int
+	sseURL             string
+	ssePublishURL      string
+	broadastsWait      int
+	compression        bool
+	compressionLevel   int
+	clientNoContext    bool
+	serverNoContext    bool
+	clientWindowBits   int
+	serverWindowBits   int
+	byteStats          bool
+	headers            []string
+	cookies            []string
+	query              string
+	jwtAlgorithm       string
+	jwtSecret          string
+	jwtKeyFile         string
+	jwtClaims          string
+	jwtTTL             time.Duration
+	jwtExpired         bool
+	jwtQueryParam      string
+	jwtHeader          string
+	identitiesFile     string
+	identityOrder      string
+	http2              bool
+	http2Streams       int
+	proxies            []string
+	tlsVerify          bool
+	tlsCAFile          string
+	tlsCertFile        string
+	tlsKeyFile         string
+	tlsMinVersion      string
+	tlsCiphers         []string
+	tlsALPN            []string
+	tlsSessionTickets  bool
+	remoteAddrs        []string
+	resolveAll         bool
+	nodeStrategy       string
+	nodeWeights        []int
+	localPortRanges    []string
+	format             string
+	filename           string
 }
 
 var (
@@ -61,17 +99,19 @@ func init() {
 }
 
 func main() {
+	serverTypeUsage := fmt.Sprintf("server type to connect to (%s)", strings.Join(benchmark.ServerTypeNames(), ", "))
+
 	rootCmd := &cobra.Command{Use: "websocket-bench", Short: fmt.Sprintf("websocket benchmark tool (%s)", version)}
 
 	cmdEcho := &cobra.Command{
-		Use:   "echo URL",
+		Use:   "echo URL [URL...]",
 		Short: "Echo stress test",
 		Long:  "Stress test 1 to 1 performance with an echo test",
 		Run:   Stress,
 	}
 	cmdEcho.PersistentFlags().StringVarP(&options.websocketOrigin, "origin", "o", "http://localhost", "websocket origin")
 	cmdEcho.PersistentFlags().StringSliceVarP(&options.localAddrs, "local-addr", "l", []string{}, "local IP address to connect from")
-	cmdEcho.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", "server type to connect to (json, binary, actioncable, phoenix)")
+	cmdEcho.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", serverTypeUsage)
 	cmdEcho.PersistentFlags().StringSliceVarP(&options.workerAddrs, "worker-addr", "w", []string{}, "worker address to distribute connections to")
 	cmdEcho.PersistentFlags().StringVarP(&options.websocketProtocol, "sub-protocol", "", "", "WS sub-protocol to use")
 	cmdEcho.Flags().IntVarP(&options.concurrent, "concurrent", "c", 50, "concurrent echo requests")
@@ -85,14 +125,15 @@ func main() {
 	cmdEcho.Flags().IntVarP(&options.stepsDelay, "steps-delay", "", 0, "Sleep for seconds between steps")
 	cmdEcho.Flags().Float64VarP(&options.commandDelay, "command-delay", "", 0, "Sleep for seconds before sending client command")
 	cmdEcho.Flags().IntVarP(&options.commandDelayChance, "command-delay-chance", "", 100, "The percentage of commands to add delay to")
+	cmdEcho.Flags().IntVarP(&options.dropPercentage, "drop-percentage", "", 0, "The percentage of connections to drop and restore mid-step (requires session recovery support)")
 	cmdEcho.Flags().StringVarP(&options.format, "format", "f", "", "output format")
 	cmdEcho.Flags().StringVarP(&options.filename, "filename", "n", "", "output filename")
-	cmdEcho.Flags().StringVarP(&options.actionCableEncoding, "action-cable-encoding", "", "json", "Action Cable messages encoding (json, msgpack, protobuf)")
-	cmdEcho.PersistentFlags().StringVarP(&options.channel, "channel", "", "{\"channel\":\"BenchmarkChannel\"}", "Action Cable channel identifier")
+	cmdEcho.PersistentFlags().AddFlagSet(benchmark.ServerTypeFlags())
+	addClientFlags(cmdEcho)
 	rootCmd.AddCommand(cmdEcho)
 
 	cmdBroadcast := &cobra.Command{
-		Use:   "broadcast URL",
+		Use:   "broadcast URL [URL...]",
 		Short: "Broadcast stress test",
 		Long:  "Stress test 1 to many performance with an broadcast test",
 		Run:   Stress,
@@ -100,7 +141,7 @@ func main() {
 	cmdBroadcast.PersistentFlags().StringVarP(&options.websocketOrigin, "origin", "o", "http://localhost", "websocket origin")
 	cmdBroadcast.PersistentFlags().StringSliceVarP(&options.localAddrs, "local-addr", "l", []string{}, "local IP address to connect from")
 	cmdBroadcast.PersistentFlags().StringSliceVarP(&options.workerAddrs, "worker-addr", "w", []string{}, "worker address to distribute connections to")
-	cmdBroadcast.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", "server type to connect to (json, binary, actioncable, phoenix)")
+	cmdBroadcast.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", serverTypeUsage)
 	cmdBroadcast.PersistentFlags().StringVarP(&options.websocketProtocol, "sub-protocol", "", "", "WS sub-protocol to use")
 	cmdBroadcast.Flags().IntVarP(&options.concurrent, "concurrent", "c", 4, "concurrent broadcast requests")
 	cmdBroadcast.Flags().IntVarP(&options.concurrentConnect, "connect-concurrent", "", 100, "concurrent connection initialization requests")
@@ -115,11 +156,14 @@ func main() {
 	cmdBroadcast.Flags().IntVarP(&options.stepsDelay, "steps-delay", "", 0, "Sleep for seconds between steps")
 	cmdBroadcast.Flags().Float64VarP(&options.commandDelay, "command-delay", "", 0, "Sleep for seconds before sending client command")
 	cmdBroadcast.Flags().IntVarP(&options.commandDelayChance, "command-delay-chance", "", 100, "The percentage of commands to add delay to")
+	cmdBroadcast.Flags().IntVarP(&options.dropPercentage, "drop-percentage", "", 0, "The percentage of connections to drop and restore mid-step (requires session recovery support)")
 	cmdBroadcast.Flags().IntVarP(&options.broadastsWait, "wait-broadcasts", "", 2, "Sleep for seconds after the last step made to collect the broadcasts")
 	cmdBroadcast.Flags().StringVarP(&options.format, "format", "f", "", "output format")
 	cmdBroadcast.Flags().StringVarP(&options.filename, "filename", "n", "", "output filename")
-	cmdBroadcast.Flags().StringVarP(&options.actionCableEncoding, "action-cable-encoding", "", "json", "Action Cable messages encoding (json, msgpack, protobuf)")
-	cmdBroadcast.PersistentFlags().StringVarP(&options.channel, "channel", "", "{\"channel\":\"BenchmarkChannel\"}", "Action Cable channel identifier")
+	cmdBroadcast.Flags().StringVarP(&options.sseURL, "sse-url", "", "", "Server-Sent Events URL to connect receivers to instead of WebSocket")
+	cmdBroadcast.Flags().StringVarP(&options.ssePublishURL, "sse-publish-url", "", "", "HTTP URL to POST broadcasts to for SSE receivers (defaults to publishing via WebSocket)")
+	cmdBroadcast.PersistentFlags().AddFlagSet(benchmark.ServerTypeFlags())
+	addClientFlags(cmdBroadcast)
 	rootCmd.AddCommand(cmdBroadcast)
 
 	cmdWorker := &cobra.Command{
@@ -133,14 +177,14 @@ func main() {
 	rootCmd.AddCommand(cmdWorker)
 
 	cmdConnect := &cobra.Command{
-		Use:   "connect URL",
+		Use:   "connect URL [URL...]",
 		Short: "Connection initialization stress test",
 		Long:  "Stress test connection initialzation",
 		Run:   Stress,
 	}
 	cmdConnect.PersistentFlags().StringVarP(&options.websocketOrigin, "origin", "o", "http://localhost", "websocket origin")
 	cmdConnect.PersistentFlags().StringSliceVarP(&options.localAddrs, "local-addr", "l", []string{}, "local IP address to connect from")
-	cmdConnect.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", "server type to connect to (json, binary, actioncable, phoenix)")
+	cmdConnect.PersistentFlags().StringVarP(&options.serverType, "server-type", "", "json", serverTypeUsage)
 	cmdConnect.PersistentFlags().StringSliceVarP(&options.workerAddrs, "worker-addr", "w", []string{}, "worker address to distribute connections to")
 	cmdConnect.Flags().IntVarP(&options.concurrent, "concurrent", "c", 50, "concurrent connection requests")
 	cmdConnect.Flags().IntVarP(&options.stepSize, "step-size", "", 5000, "number of clients to connect at each step")
@@ -151,15 +195,56 @@ func main() {
 	cmdConnect.Flags().IntVarP(&options.commandDelayChance, "command-delay-chance", "", 100, "The percentage of commands to add delay to")
 	cmdConnect.Flags().StringVarP(&options.format, "format", "f", "", "output format")
 	cmdConnect.Flags().StringVarP(&options.filename, "filename", "n", "", "output filename")
-	cmdConnect.Flags().StringVarP(&options.actionCableEncoding, "action-cable-encoding", "", "json", "Action Cable messages encoding (json, msgpack, protobuf)")
-	cmdConnect.PersistentFlags().StringVarP(&options.channel, "channel", "", "{\"channel\":\"BenchmarkChannel\"}", "Action Cable channel identifier")
+	cmdConnect.PersistentFlags().AddFlagSet(benchmark.ServerTypeFlags())
+	addClientFlags(cmdConnect)
 	rootCmd.AddCommand(cmdConnect)
 
 	rootCmd.Execute()
 }
 
+// addClientFlags registers the client connection flags shared by the benchmark commands
+func addClientFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.BoolVarP(&options.compression, "compression", "", false, "negotiate permessage-deflate compression")
+	flags.IntVarP(&options.compressionLevel, "compression-level", "", -1, "deflate compression level (-2 to 9, -1 is the default level)")
+	flags.BoolVarP(&options.clientNoContext, "client-no-context-takeover", "", false, "request client_no_context_takeover (reset the compression context for every message sent)")
+	flags.BoolVarP(&options.serverNoContext, "server-no-context-takeover", "", false, "request server_no_context_takeover (reset the compression context for every message received)")
+	flags.IntVarP(&options.clientWindowBits, "client-max-window-bits", "", 0, "client_max_window_bits to offer (8 to 15, 0 to let the server choose)")
+	flags.IntVarP(&options.serverWindowBits, "server-max-window-bits", "", 0, "server_max_window_bits to request (8 to 15, 0 for no limit)")
+	flags.BoolVarP(&options.byteStats, "byte-stats", "", false, "report the wire and application bytes sent and received per step (implied by --compression)")
+	flags.StringArrayVarP(&options.headers, "header", "", []string{}, "HTTP header to add to the upgrade request (\"Name: value\", {{id}} is replaced with the client ID)")
+	flags.StringArrayVarP(&options.cookies, "cookie", "", []string{}, "cookie to send with the upgrade request (\"name=value\", {{id}} is replaced with the client ID)")
+	flags.StringVarP(&options.query, "query", "", "", "query string to add to the URL (e.g. \"user_id={{id}}\", {{id}} is replaced with the client ID)")
+	flags.StringVarP(&options.jwtAlgorithm, "jwt-alg", "", "HS256", "JWT signing algorithm (HS256, RS256)")
+	flags.StringVarP(&options.jwtSecret, "jwt-secret", "", "", "secret to sign per-client JWTs with (HS256)")
+	flags.StringVarP(&options.jwtKeyFile, "jwt-key-file", "", "", "PEM RSA private key file to sign per-client JWTs with (RS256)")
+	flags.StringVarP(&options.jwtClaims, "jwt-claims", "", "{\"ext\":\"{\\\"user_id\\\":\\\"{{id}}\\\"}\"}", "JWT claims template (JSON, {{id}} is replaced with the client ID)")
+	flags.DurationVarP(&options.jwtTTL, "jwt-ttl", "", time.Hour, "JWT lifetime used to set the exp claim (0 to omit it)")
+	flags.BoolVarP(&options.jwtExpired, "jwt-expired", "", false, "send expired JWTs (to measure rejection cost)")
+	flags.StringVarP(&options.jwtQueryParam, "jwt-query-param", "", "jid", "query parameter to pass the JWT in (empty to not pass it in the query)")
+	flags.StringVarP(&options.jwtHeader, "jwt-header", "", "", "HTTP header to pass the JWT in (e.g. X-JID)")
+	flags.StringVarP(&options.identitiesFile, "identities-file", "", "", "per-client data file (CSV with a header row or JSON lines); fields are available as {{field}} placeholders, url and channel fields override the target URL and channel")
+	flags.StringVarP(&options.identityOrder, "identity-order", "", benchmark.IdentityOrderRoundRobin, "how clients consume the identities (sequential: each one once, round-robin: start over when exhausted)")
+	flags.BoolVarP(&options.http2, "http2", "", false, "connect via HTTP/2 extended CONNECT (RFC 8441), multiplexing clients over shared TCP connections")
+	flags.IntVarP(&options.http2Streams, "http2-streams-per-conn", "", 100, "max number of clients per HTTP/2 connection (0 to be limited by the server only)")
+	flags.StringSliceVarP(&options.proxies, "proxy", "", []string{}, "HTTP CONNECT or SOCKS5 proxy to connect through (http://[user:password@]host:port, socks5://...); several proxies are assigned to the client pools in turn")
+	flags.BoolVarP(&options.tlsVerify, "tls-verify", "", false, "verify the server certificate (implied by --tls-ca-file)")
+	flags.StringVarP(&options.tlsCAFile, "tls-ca-file", "", "", "PEM CA bundle to verify the server certificate with")
+	flags.StringVarP(&options.tlsCertFile, "tls-cert-file", "", "", "PEM client certificate file (mTLS)")
+	flags.StringVarP(&options.tlsKeyFile, "tls-key-file", "", "", "PEM client private key file (mTLS)")
+	flags.StringVarP(&options.tlsMinVersion, "tls-min-version", "", "", "minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flags.StringSliceVarP(&options.tlsCiphers, "tls-ciphers", "", []string{}, "TLS 1.0-1.2 cipher suites in the preference order (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)")
+	flags.StringSliceVarP(&options.tlsALPN, "tls-alpn", "", []string{}, "ALPN protocols to offer (e.g. http/1.1)")
+	flags.BoolVarP(&options.tlsSessionTickets, "tls-session-tickets", "", false, "resume TLS sessions with session tickets")
+	flags.StringSliceVarP(&options.remoteAddrs, "remote-addr", "", []string{}, "address to connect to instead of the URL host (host:port or unix:/path/to/socket); clients are spread across several addresses in turn")
+	flags.BoolVarP(&options.resolveAll, "resolve-all", "", false, "spread clients across all the addresses the URL host resolves to")
+	flags.StringVarP(&options.nodeStrategy, "node-strategy", "", benchmark.NodeStrategyRoundRobin, "how to assign clients to several URLs (round-robin, weighted, hash)")
+	flags.IntSliceVarP(&options.nodeWeights, "node-weights", "", []int{}, "URL weights for the weighted node strategy (in the URLs order)")
+	flags.StringSliceVarP(&options.localPortRanges, "local-port-range", "", []string{}, "source port range to bind on every local address (e.g. 10000-60000); the kernel picks ephemeral ports if not set")
+}
+
 func Stress(cmd *cobra.Command, args []string) {
-	if len(args) != 1 {
+	if len(args) == 0 {
 		cmd.Help()
 		os.Exit(1)
 	}
@@ -190,6 +275,7 @@ func Stress(cmd *cobra.Command, args []string) {
 	config.StepDelay = time.Duration(options.stepsDelay) * time.Second
 	config.CommandDelay = time.Duration(options.commandDelay) * time.Second
 	config.CommandDelayChance = options.commandDelayChance
+	config.DropPercentage = options.dropPercentage
 	config.WaitBroadcastsSeconds = options.broadastsWait
 
 	var writer io.Writer
@@ -207,14 +293,97 @@ func Stress(cmd *cobra.Command, args []string) {
 		config.ResultRecorder = benchmark.NewTextResultRecorder(writer)
 	}
 
-	benchmark.CableConfig.Channel = options.channel
-	benchmark.CableConfig.Encoding = options.actionCableEncoding
+	if options.dropPercentage < 0 || options.dropPercentage >= 100 {
+		log.Fatalf("invalid drop percentage: %d", options.dropPercentage)
+	}
+
+	if options.compression {
+		if options.compressionLevel < -2 || options.compressionLevel > 9 {
+			log.Fatalf("invalid compression level: %d", options.compressionLevel)
+		}
+		for _, bits := range []int{options.clientWindowBits, options.serverWindowBits} {
+			if bits != 0 && (bits < 8 || bits > 15) {
+				log.Fatalf("invalid max window bits: %d", bits)
+			}
+		}
+	}
+
+	benchmark.CompressionConfig.Enabled = options.compression
+	benchmark.CompressionConfig.Level = options.compressionLevel
+	benchmark.CompressionConfig.ClientNoContextTakeover = options.clientNoContext
+	benchmark.CompressionConfig.ServerNoContextTakeover = options.serverNoContext
+	benchmark.CompressionConfig.ClientMaxWindowBits = options.clientWindowBits
+	benchmark.CompressionConfig.ServerMaxWindowBits = options.serverWindowBits
+	benchmark.CompressionConfig.ByteStats = options.byteStats
+
+	benchmark.TLSConfig.Verify = options.tlsVerify
+	benchmark.TLSConfig.CAFile = options.tlsCAFile
+	benchmark.TLSConfig.CertFile = options.tlsCertFile
+	benchmark.TLSConfig.KeyFile = options.tlsKeyFile
+	benchmark.TLSConfig.MinVersion = options.tlsMinVersion
+	benchmark.TLSConfig.Ciphers = options.tlsCiphers
+	benchmark.TLSConfig.ALPN = options.tlsALPN
+	benchmark.TLSConfig.SessionTickets = options.tlsSessionTickets
+
+	if err := benchmark.ConfigureTLS(); err != nil {
+		log.Fatal(err)
+	}
+
+	benchmark.HTTP2Config.Enabled = options.http2
+	benchmark.HTTP2Config.StreamsPerConn = options.http2Streams
+
+	header, err := parseHeaders(options.headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	benchmark.HandshakeConfig.Header = header
+	benchmark.HandshakeConfig.Cookies = options.cookies
+	benchmark.HandshakeConfig.Query = strings.TrimPrefix(options.query, "?")
+
+	benchmark.JWTConfig = benchmark.JWTOptions{
+		Algorithm:  options.jwtAlgorithm,
+		Secret:     options.jwtSecret,
+		KeyFile:    options.jwtKeyFile,
+		Claims:     options.jwtClaims,
+		TTL:        options.jwtTTL,
+		Expired:    options.jwtExpired,
+		QueryParam: options.jwtQueryParam,
+		Header:     options.jwtHeader,
+	}
+
+	if err := benchmark.ConfigureJWT(); err != nil {
+		log.Fatal(err)
+	}
+
+	if options.identitiesFile != "" {
+		if err := benchmark.LoadIdentities(options.identitiesFile, options.identityOrder); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	serverType, err := benchmark.LookupServerType(options.serverType)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if options.dropPercentage > 0 && !serverType.SessionRecovery {
+		log.Fatalf("server type %s doesn't support session recovery required by --drop-percentage", serverType.Name)
+	}
+
+	if err := benchmark.ConfigureServerType(serverType.Name, nil); err != nil {
+		log.Fatal(err)
+	}
 
 	wsconfig, err := websocket.NewConfig(config.WebsocketURL, config.WebsocketOrigin)
 	if err != nil {
 		panic(fmt.Errorf("failed to generate WS config: %v", err))
 	}
 
+	if serverType.Handshake != nil {
+		serverType.Handshake(wsconfig)
+	}
+
 	if options.websocketProtocol != "" {
 		wsconfig.Protocol = []string{options.websocketProtocol}
 	}
@@ -222,16 +391,106 @@ func Stress(cmd *cobra.Command, args []string) {
 	benchmark.RemoteAddr.Config = wsconfig
 	benchmark.RemoteAddr.Secure = wsconfig.Location.Scheme == "wss"
 
-	if raddr, host, err := parseRemoteAddr(wsconfig.Location.Host); err != nil {
+	if raddr, host, err := benchmark.ResolveRemoteAddr(wsconfig.Location.Host); err != nil {
 		panic(fmt.Errorf("failed to parse remote address: %v", err))
 	} else {
-		benchmark.RemoteAddr.Addr = raddr
+		benchmark.RemoteAddr.Addrs = []net.Addr{raddr}
 		benchmark.RemoteAddr.Host = host
 	}
 
+	if options.resolveAll {
+		if len(options.remoteAddrs) > 0 {
+			log.Fatal("--resolve-all and --remote-addr can't be used together")
+		}
+
+		raddrs, err := benchmark.ResolveAllRemoteAddrs(wsconfig.Location.Host)
+		if err != nil {
+			log.Fatalf("failed to resolve remote addresses: %v", err)
+		}
+		benchmark.RemoteAddr.Addrs = raddrs
+	}
+
+	if len(options.remoteAddrs) > 0 {
+		benchmark.RemoteAddr.Addrs = nil
+		for _, s := range options.remoteAddrs {
+			raddr, err := benchmark.ParseRemoteAddr(s)
+			if err != nil {
+				log.Fatalf("failed to parse remote address %s: %v", s, err)
+			}
+			benchmark.RemoteAddr.Addrs = append(benchmark.RemoteAddr.Addrs, raddr)
+		}
+	}
+
+	// Several URLs are the cluster nodes the clients are spread across
+	if len(args) > 1 {
+		if len(options.remoteAddrs) > 0 || options.resolveAll {
+			log.Fatal("remote addresses are not supported with several URLs")
+		}
+		if options.sseURL != "" || len(options.workerAddrs) > 0 {
+			log.Fatal("several URLs are not supported by SSE receivers and workers")
+		}
+
+		for _, u := range args {
+			if err := benchmark.AddClusterNode(u); err != nil {
+				log.Fatalf("failed to add cluster node %s: %v", u, err)
+			}
+		}
+	}
+
+	benchmark.Cluster.Strategy = options.nodeStrategy
+	benchmark.Cluster.Weights = options.nodeWeights
+
+	if err := benchmark.ConfigureCluster(); err != nil {
+		log.Fatal(err)
+	}
+
 	localAddrs := parseTCPAddrs(options.localAddrs)
-	for _, a := range localAddrs {
-		config.ClientPools = append(config.ClientPools, benchmark.NewLocalClientPool(a))
+
+	for _, r := range options.localPortRanges {
+		portRange, err := benchmark.ParsePortRange(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		benchmark.LocalPortRanges = append(benchmark.LocalPortRanges, portRange)
+	}
+
+	if options.sseURL != "" && len(benchmark.LocalPortRanges) > 0 {
+		log.Fatal("local port ranges are not supported by SSE receivers")
+	}
+
+	if options.sseURL != "" && len(options.proxies) > 0 {
+		log.Fatal("proxies are not supported by SSE receivers")
+	}
+
+	if options.sseURL != "" && (len(options.remoteAddrs) > 0 || options.resolveAll) {
+		log.Fatal("remote addresses are not supported by SSE receivers")
+	}
+
+	// Every local address and proxy gets a client pool
+	poolsCount := len(localAddrs)
+	if len(options.proxies) > poolsCount {
+		poolsCount = len(options.proxies)
+	}
+
+	for i := 0; i < poolsCount; i++ {
+		a := localAddrs[i%len(localAddrs)]
+
+		if options.sseURL != "" {
+			config.ClientPools = append(config.ClientPools, benchmark.NewSSEClientPool(a, options.sseURL, options.ssePublishURL))
+			continue
+		}
+
+		lcp := benchmark.NewLocalClientPool(a)
+		if len(options.proxies) > 0 {
+			if err := lcp.UseProxy(options.proxies[i%len(options.proxies)]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		config.ClientPools = append(config.ClientPools, lcp)
+	}
+
+	if options.sseURL != "" && len(options.workerAddrs) > 0 {
+		log.Fatal("SSE receivers are not supported by workers")
 	}
 
 	for _, a := range options.workerAddrs {
@@ -242,6 +501,8 @@ func Stress(cmd *cobra.Command, args []string) {
 		config.ClientPools = append(config.ClientPools, rcp)
 	}
 
+	checkLocalCapacity(config, cmd.Name() == "connect", len(args))
+
 	if cmd.Name() == "connect" {
 		b := benchmark.NewConnect(config)
 		err := b.Run()
@@ -281,28 +542,50 @@ func parseTCPAddrs(stringAddrs []string) []*net.TCPAddr {
 	return tcpAddrs
 }
 
-func parseRemoteAddr(url string) (*net.TCPAddr, string, error) {
-	host, port, err := net.SplitHostPort(url)
-	if err != nil {
-		return nil, "", err
+// checkLocalCapacity fails if the planned number of clients exceeds the
+// number of source ports on the local addresses
+func checkLocalCapacity(config *benchmark.Config, connect bool, urls int) {
+	// The clients count is unknown if the benchmark runs until the RTT limit,
+	// and HTTP/2 clients share the connections
+	if config.TotalSteps == 0 || options.http2 {
+		return
 	}
 
-	destIPs, err := net.LookupHost(host)
-	if err != nil {
-		return nil, "", err
+	planned := config.StepSize * config.TotalSteps
+	if !connect && config.InitialClients > 0 {
+		planned = config.InitialClients + config.StepSize*(config.TotalSteps-1)
 	}
 
-	nport, err := strconv.ParseUint(port, 10, 16)
-	if err != nil {
-		return nil, "", err
+	// The workers connect the rest of the clients from their own addresses
+	localPools := len(config.ClientPools) - len(options.workerAddrs)
+	planned = (planned*localPools + len(config.ClientPools) - 1) / len(config.ClientPools)
+
+	destinations := len(benchmark.RemoteAddr.Addrs)
+	if urls > destinations {
+		destinations = urls
 	}
 
-	ip := net.ParseIP(destIPs[0])
-	if host == "localhost" {
-		ip = nil
+	capacity := benchmark.LocalAddrCapacity(len(options.localAddrs), destinations)
+	if capacity > 0 && planned > capacity {
+		log.Fatalf(
+			"%d clients are planned but the local addresses allow %d connections; add local addresses (--local-addr) or source ports (--local-port-range)",
+			planned, capacity,
+		)
+	}
+}
+
+func parseHeaders(headers []string) (http.Header, error) {
+	header := make(http.Header)
+
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header: %s", h)
+		}
+		header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
 	}
 
-	return &net.TCPAddr{IP: ip, Port: int(nport)}, host, nil
+	return header, nil
 }
 
 func openFileWriter() (io.Writer, context.CancelFunc) {